@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/AditiKulkarni9/clickhouse-flatfile-tool/services"
+	"github.com/AditiKulkarni9/clickhouse-flatfile-tool/utils"
+)
+
+// defaultUsersFile is the local user store used when AUTH_USERS_FILE is unset.
+const defaultUsersFile = "users.json"
+
+// defaultRevokedFile keeps revoked tokens next to the user store when
+// AUTH_REVOKED_FILE is unset.
+const defaultRevokedFile = "revoked_tokens.json"
+
+// loadKeys configures token signing from the environment. Without any JWT
+// settings a random secret is used.
+func loadKeys() error {
+	config, err := utils.KeyConfigFromEnv()
+	if err != nil {
+		return err
+	}
+	if len(config.Secret) == 0 && config.PrivateKeyFile == "" {
+		return nil
+	}
+	keys, err := utils.LoadKeySet(config)
+	if err != nil {
+		return err
+	}
+	utils.SetKeySet(keys)
+	return nil
+}
+
+// reloadKeysOnHangup re-reads the key files on SIGHUP, so keys can be rotated
+// without a restart. The old key set stays active if loading fails.
+func reloadKeysOnHangup() {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	for range hangup {
+		if err := loadKeys(); err != nil {
+			log.Printf("Failed to reload JWT keys: %v", err)
+			continue
+		}
+		log.Println("Reloaded JWT keys")
+	}
+}
+
+// newTokenService builds the login backend selected by AUTH_BACKEND ("local"
+// or "htpasswd") with token lifetimes from JWT_ACCESS_TTL and JWT_REFRESH_TTL.
+func newTokenService() (*services.TokenService, error) {
+	accessTTL, err := durationEnv("JWT_ACCESS_TTL")
+	if err != nil {
+		return nil, err
+	}
+	refreshTTL, err := durationEnv("JWT_REFRESH_TTL")
+	if err != nil {
+		return nil, err
+	}
+
+	var auth services.Authenticator
+	var path string
+	switch backend := os.Getenv("AUTH_BACKEND"); backend {
+	case "", "local":
+		path = usersFile()
+		auth, err = services.NewLocalUserStore(path)
+	case "htpasswd":
+		path = os.Getenv("AUTH_HTPASSWD_FILE")
+		if path == "" {
+			return nil, fmt.Errorf("AUTH_HTPASSWD_FILE is required for the htpasswd backend")
+		}
+		auth, err = services.NewHtpasswdAuthenticator(path)
+	default:
+		return nil, fmt.Errorf("unknown AUTH_BACKEND %q", backend)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := services.NewTokenService(auth, accessTTL, refreshTTL)
+	revokedFile := os.Getenv("AUTH_REVOKED_FILE")
+	if revokedFile == "" {
+		revokedFile = filepath.Join(filepath.Dir(path), defaultRevokedFile)
+	}
+	if err := tokens.PersistRevocations(revokedFile); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// durationEnv parses a Go duration such as "15m" from the environment. Unset
+// variables return zero, which selects the default.
+func durationEnv(name string) (time.Duration, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid %s %q", name, value)
+	}
+	return d, nil
+}
+
+func usersFile() string {
+	if path := os.Getenv("AUTH_USERS_FILE"); path != "" {
+		return path
+	}
+	return defaultUsersFile
+}
+
+// addUser creates or updates a user in the local user store, reading the
+// password from standard input.
+func addUser(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: useradd <username>")
+	}
+	store, err := services.NewLocalUserStore(usersFile())
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprint(os.Stderr, "Password: ")
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && password == "" {
+		return fmt.Errorf("failed to read password: %v", err)
+	}
+	if err := store.SetPassword(args[0], strings.TrimRight(password, "\r\n")); err != nil {
+		return err
+	}
+	log.Printf("Saved user %s to %s", args[0], usersFile())
+	return nil
+}
@@ -0,0 +1,376 @@
+package chtypes
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+// NullText is how NULL is written to and recognised in CSV, matching
+// ClickHouse's own CSV format.
+const NullText = `\N`
+
+const (
+	dateLayout     = "2006-01-02"
+	dateTimeLayout = "2006-01-02 15:04:05"
+)
+
+var (
+	typeTime    = reflect.TypeOf(time.Time{})
+	typeBigInt  = reflect.TypeOf(big.Int{})
+	typeDecimal = reflect.TypeOf(decimal.Decimal{})
+	typeUUID    = reflect.TypeOf(uuid.UUID{})
+	typeIP      = reflect.TypeOf(net.IP{})
+)
+
+// GoType returns the Go type clickhouse-go scans values of t into.
+func (t *Type) GoType() (reflect.Type, error) {
+	if t.IsComposite() {
+		return t.compositeGoType()
+	}
+	base, err := t.baseGoType()
+	if err != nil {
+		return nil, err
+	}
+	if t.Nullable {
+		return reflect.PointerTo(base), nil
+	}
+	return base, nil
+}
+
+func (t *Type) baseGoType() (reflect.Type, error) {
+	switch t.Kind {
+	case Int8:
+		return reflect.TypeOf(int8(0)), nil
+	case Int16:
+		return reflect.TypeOf(int16(0)), nil
+	case Int32:
+		return reflect.TypeOf(int32(0)), nil
+	case Int64:
+		return reflect.TypeOf(int64(0)), nil
+	case UInt8:
+		return reflect.TypeOf(uint8(0)), nil
+	case UInt16:
+		return reflect.TypeOf(uint16(0)), nil
+	case UInt32:
+		return reflect.TypeOf(uint32(0)), nil
+	case UInt64:
+		return reflect.TypeOf(uint64(0)), nil
+	case Int128, Int256, UInt128, UInt256:
+		return typeBigInt, nil
+	case Float32:
+		return reflect.TypeOf(float32(0)), nil
+	case Float64:
+		return reflect.TypeOf(float64(0)), nil
+	case Decimal:
+		return typeDecimal, nil
+	case Bool:
+		return reflect.TypeOf(false), nil
+	case String, FixedString, Enum8, Enum16:
+		return reflect.TypeOf(""), nil
+	case UUID:
+		return typeUUID, nil
+	case Date, Date32, DateTime, DateTime64:
+		return typeTime, nil
+	case IPv4, IPv6:
+		return typeIP, nil
+	}
+	return nil, fmt.Errorf("unsupported ClickHouse type %s", t)
+}
+
+// ScanTarget returns a pointer suitable for passing to rows.Scan.
+func (t *Type) ScanTarget() (interface{}, error) {
+	typ, err := t.GoType()
+	if err != nil {
+		return nil, err
+	}
+	return reflect.New(typ).Interface(), nil
+}
+
+// Encode formats a scanned value as CSV text. v may be the value itself or
+// the pointer returned by ScanTarget. Composite values use FormatClickHouse.
+func (t *Type) Encode(v interface{}) (string, error) {
+	return t.EncodeWith(v, FormatClickHouse)
+}
+
+// EncodeWith is like Encode but writes composite values in the given format.
+func (t *Type) EncodeWith(v interface{}, format CompositeFormat) (string, error) {
+	rv := reflect.ValueOf(v)
+	for rv.IsValid() && rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return NullText, nil
+		}
+		rv = rv.Elem()
+	}
+	if !rv.IsValid() {
+		return NullText, nil
+	}
+	if t.IsComposite() {
+		return t.encodeComposite(rv, format)
+	}
+	return t.encodeValue(rv.Interface())
+}
+
+func (t *Type) encodeValue(v interface{}) (string, error) {
+	switch val := v.(type) {
+	case string:
+		if t.Kind == FixedString {
+			return strings.TrimRight(val, "\x00"), nil
+		}
+		return val, nil
+	case bool:
+		return strconv.FormatBool(val), nil
+	case int8, int16, int32, int64, int, uint8, uint16, uint32, uint64, uint:
+		return fmt.Sprint(val), nil
+	case float32:
+		return formatFloat(float64(val), 32), nil
+	case float64:
+		return formatFloat(val, 64), nil
+	case big.Int:
+		return val.String(), nil
+	case decimal.Decimal:
+		return val.StringFixed(int32(t.Scale)), nil
+	case uuid.UUID:
+		return val.String(), nil
+	case net.IP:
+		if t.Kind == IPv4 {
+			if v4 := val.To4(); v4 != nil {
+				return v4.String(), nil
+			}
+		}
+		return val.String(), nil
+	case time.Time:
+		return t.formatTime(val), nil
+	}
+	return "", fmt.Errorf("cannot encode %T as %s", v, t)
+}
+
+func formatFloat(f float64, bits int) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case math.IsNaN(f):
+		return "nan"
+	}
+	return strconv.FormatFloat(f, 'g', -1, bits)
+}
+
+func (t *Type) formatTime(tm time.Time) string {
+	switch t.Kind {
+	case Date, Date32:
+		return tm.Format(dateLayout)
+	case DateTime64:
+		layout := dateTimeLayout
+		if t.Precision > 0 {
+			layout += "." + strings.Repeat("0", t.Precision)
+		}
+		return tm.In(t.Location()).Format(layout)
+	}
+	return tm.In(t.Location()).Format(dateTimeLayout)
+}
+
+// Decode parses CSV text into a value accepted by batch.Append for t.
+// Composite values are expected in FormatClickHouse.
+func (t *Type) Decode(s string) (interface{}, error) {
+	return t.DecodeWith(s, FormatClickHouse)
+}
+
+// DecodeWith is like Decode but reads composite values in the given format.
+func (t *Type) DecodeWith(s string, format CompositeFormat) (interface{}, error) {
+	if t.IsComposite() {
+		return t.decodeComposite(s, format)
+	}
+	if t.Nullable && (s == NullText || (s == "" && t.Kind != String && t.Kind != FixedString)) {
+		return nil, nil
+	}
+
+	switch t.Kind {
+	case Int8, Int16, Int32, Int64:
+		n, err := strconv.ParseInt(strings.TrimSpace(s), 10, bitSize(t.Kind))
+		if err != nil {
+			return nil, err
+		}
+		switch t.Kind {
+		case Int8:
+			return int8(n), nil
+		case Int16:
+			return int16(n), nil
+		case Int32:
+			return int32(n), nil
+		}
+		return n, nil
+	case UInt8, UInt16, UInt32, UInt64:
+		n, err := strconv.ParseUint(strings.TrimSpace(s), 10, bitSize(t.Kind))
+		if err != nil {
+			return nil, err
+		}
+		switch t.Kind {
+		case UInt8:
+			return uint8(n), nil
+		case UInt16:
+			return uint16(n), nil
+		case UInt32:
+			return uint32(n), nil
+		}
+		return n, nil
+	case Int128, Int256, UInt128, UInt256:
+		n, ok := new(big.Int).SetString(strings.TrimSpace(s), 10)
+		if !ok {
+			return nil, fmt.Errorf("invalid integer %q", s)
+		}
+		if (t.Kind == UInt128 || t.Kind == UInt256) && n.Sign() < 0 {
+			return nil, fmt.Errorf("negative value %q for unsigned type", s)
+		}
+		return n, nil
+	case Float32:
+		f, err := strconv.ParseFloat(strings.TrimSpace(s), 32)
+		return float32(f), err
+	case Float64:
+		return strconv.ParseFloat(strings.TrimSpace(s), 64)
+	case Decimal:
+		return decimal.NewFromString(strings.TrimSpace(s))
+	case Bool:
+		return strconv.ParseBool(strings.TrimSpace(s))
+	case String, FixedString, Enum8, Enum16:
+		if t.Kind == FixedString && len(s) > t.Length {
+			return nil, fmt.Errorf("value longer than %d bytes", t.Length)
+		}
+		return s, nil
+	case UUID:
+		return uuid.Parse(strings.TrimSpace(s))
+	case IPv4, IPv6:
+		ip := net.ParseIP(strings.TrimSpace(s))
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP address %q", s)
+		}
+		if t.Kind == IPv4 {
+			if ip = ip.To4(); ip == nil {
+				return nil, fmt.Errorf("%q is not an IPv4 address", s)
+			}
+		}
+		return ip, nil
+	case Date, Date32:
+		return parseTime(strings.TrimSpace(s), time.UTC)
+	case DateTime, DateTime64:
+		return parseTime(strings.TrimSpace(s), t.Location())
+	}
+	return nil, fmt.Errorf("unsupported ClickHouse type %s", t)
+}
+
+// DecodeValue converts a value read from a typed file into a value accepted
+// by batch.Append for t. Strings are decoded like CSV text, with composite
+// values in the given format; other values are what encoding/json decodes
+// with UseNumber, with nil for null.
+func (t *Type) DecodeValue(v interface{}, format CompositeFormat) (interface{}, error) {
+	switch val := v.(type) {
+	case string:
+		return t.DecodeWith(val, format)
+	case nil:
+		if !t.IsComposite() {
+			if t.Nullable {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("null is not allowed for %s", t)
+		}
+	case json.Number:
+		if !t.IsComposite() {
+			return t.Decode(val.String())
+		}
+	case bool:
+		if !t.IsComposite() {
+			return t.Decode(strconv.FormatBool(val))
+		}
+	default:
+		if !t.IsComposite() {
+			// A list or object loaded into a scalar column is kept as JSON.
+			data, err := json.Marshal(val)
+			if err != nil {
+				return nil, err
+			}
+			return t.Decode(string(data))
+		}
+	}
+	rv, err := t.fromJSON(v)
+	if err != nil {
+		return nil, err
+	}
+	return rv.Interface(), nil
+}
+
+// Default returns the value ClickHouse stores in a column of type t when an
+// insert gives none: NULL for Nullable types, and otherwise zero, an empty
+// string or collection, the Unix epoch or the first value of an Enum.
+func (t *Type) Default() (interface{}, error) {
+	if t.IsComposite() {
+		rv, err := t.goValue(nil)
+		if err != nil {
+			return nil, err
+		}
+		return rv.Interface(), nil
+	}
+	if t.Nullable {
+		return nil, nil
+	}
+	switch t.Kind {
+	case Int128, Int256, UInt128, UInt256:
+		return new(big.Int), nil
+	case Date, Date32:
+		return time.Unix(0, 0).UTC(), nil
+	case DateTime, DateTime64:
+		return time.Unix(0, 0).In(t.Location()), nil
+	case IPv4:
+		return net.IPv4zero.To4(), nil
+	case IPv6:
+		return net.IPv6zero, nil
+	case Enum8, Enum16:
+		if len(t.Params) > 0 {
+			name, _, _ := strings.Cut(t.Params[0], "=")
+			return unquote(name), nil
+		}
+	}
+	typ, err := t.baseGoType()
+	if err != nil {
+		return nil, err
+	}
+	return reflect.Zero(typ).Interface(), nil
+}
+
+func bitSize(k Kind) int {
+	switch k {
+	case Int8, UInt8:
+		return 8
+	case Int16, UInt16:
+		return 16
+	case Int32, UInt32:
+		return 32
+	}
+	return 64
+}
+
+// parseTime accepts the layouts ClickHouse itself writes and accepts in CSV,
+// plus RFC 3339 and Unix timestamps.
+func parseTime(s string, loc *time.Location) (time.Time, error) {
+	for _, layout := range []string{dateTimeLayout, dateLayout, "2006-01-02T15:04:05"} {
+		if tm, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return tm, nil
+		}
+	}
+	if tm, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return tm, nil
+	}
+	if secs, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(secs, 0).In(loc), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid date/time %q", s)
+}
@@ -0,0 +1,689 @@
+package chtypes
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// CompositeFormat selects how Array, Map, Tuple and Nested values are
+// written to and read from a single CSV field.
+type CompositeFormat string
+
+const (
+	// FormatClickHouse uses ClickHouse literals: ['a','b'], {'k':1}, ('a',1).
+	FormatClickHouse CompositeFormat = "clickhouse"
+	// FormatJSON uses JSON arrays and objects; named tuples become objects.
+	FormatJSON CompositeFormat = "json"
+)
+
+// ParseCompositeFormat validates a format name. An empty name selects
+// FormatClickHouse.
+func ParseCompositeFormat(s string) (CompositeFormat, error) {
+	switch CompositeFormat(strings.ToLower(s)) {
+	case "", FormatClickHouse:
+		return FormatClickHouse, nil
+	case FormatJSON:
+		return FormatJSON, nil
+	}
+	return "", fmt.Errorf("unknown composite format %q", s)
+}
+
+// nestedTuple returns the tuple type each element of a Nested value has.
+func (t *Type) nestedTuple() *Type {
+	return &Type{Kind: Tuple, Name: "Tuple", Fields: t.Fields}
+}
+
+// compositeGoType returns the Go type clickhouse-go uses for composite types.
+func (t *Type) compositeGoType() (reflect.Type, error) {
+	switch t.Kind {
+	case Array:
+		elem, err := t.Elem.GoType()
+		if err != nil {
+			return nil, err
+		}
+		return reflect.SliceOf(elem), nil
+	case Nested:
+		return reflect.SliceOf(typeTuple), nil
+	case Map:
+		key, err := t.Key.GoType()
+		if err != nil {
+			return nil, err
+		}
+		if !key.Comparable() {
+			return nil, fmt.Errorf("unsupported Map key type %s", t.Key)
+		}
+		value, err := t.Value.GoType()
+		if err != nil {
+			return nil, err
+		}
+		return reflect.MapOf(key, value), nil
+	case Tuple:
+		return typeTuple, nil
+	}
+	return nil, fmt.Errorf("%s is not a composite type", t)
+}
+
+var typeTuple = reflect.TypeOf([]interface{}{})
+
+// indirect follows pointers and interfaces. It returns an invalid Value for nil.
+func indirect(rv reflect.Value) reflect.Value {
+	for rv.IsValid() && (rv.Kind() == reflect.Pointer || rv.Kind() == reflect.Interface) {
+		if rv.IsNil() {
+			return reflect.Value{}
+		}
+		rv = rv.Elem()
+	}
+	return rv
+}
+
+// quoted reports whether scalar literals of t are written in quotes.
+func (t *Type) quoted() bool {
+	switch t.Kind {
+	case String, FixedString, Enum8, Enum16, UUID, Date, Date32, DateTime, DateTime64, IPv4, IPv6:
+		return true
+	}
+	return false
+}
+
+// writeLiteral appends the ClickHouse literal for rv to b.
+func (t *Type) writeLiteral(b *strings.Builder, rv reflect.Value) error {
+	rv = indirect(rv)
+	if !rv.IsValid() {
+		b.WriteString("NULL")
+		return nil
+	}
+
+	switch t.Kind {
+	case Array, Nested:
+		elem := t.Elem
+		if t.Kind == Nested {
+			elem = t.nestedTuple()
+		}
+		b.WriteByte('[')
+		for i := 0; i < rv.Len(); i++ {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			if err := elem.writeLiteral(b, rv.Index(i)); err != nil {
+				return err
+			}
+		}
+		b.WriteByte(']')
+	case Map:
+		entries, err := t.mapEntries(rv, func(kt *Type, k reflect.Value) (string, error) {
+			var kb strings.Builder
+			err := kt.writeLiteral(&kb, k)
+			return kb.String(), err
+		})
+		if err != nil {
+			return err
+		}
+		b.WriteByte('{')
+		for i, e := range entries {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(e.key)
+			b.WriteByte(':')
+			if err := t.Value.writeLiteral(b, e.value); err != nil {
+				return err
+			}
+		}
+		b.WriteByte('}')
+	case Tuple:
+		values, err := t.tupleValues(rv)
+		if err != nil {
+			return err
+		}
+		b.WriteByte('(')
+		for i, f := range t.Fields {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			if err := f.Type.writeLiteral(b, values[i]); err != nil {
+				return err
+			}
+		}
+		b.WriteByte(')')
+	default:
+		text, err := t.encodeValue(rv.Interface())
+		if err != nil {
+			return err
+		}
+		if t.quoted() {
+			b.WriteByte('\'')
+			b.WriteString(escapeQuoted(text))
+			b.WriteByte('\'')
+		} else {
+			b.WriteString(text)
+		}
+	}
+	return nil
+}
+
+type mapEntry struct {
+	key   string
+	value reflect.Value
+}
+
+// mapEntries returns the entries of a map value ordered by their encoded key,
+// so output is deterministic.
+func (t *Type) mapEntries(rv reflect.Value, encodeKey func(*Type, reflect.Value) (string, error)) ([]mapEntry, error) {
+	entries := make([]mapEntry, 0, rv.Len())
+	iter := rv.MapRange()
+	for iter.Next() {
+		key, err := encodeKey(t.Key, iter.Key())
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, mapEntry{key: key, value: iter.Value()})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].key < entries[j].key })
+	return entries, nil
+}
+
+// tupleValues returns a tuple's elements in field order. The driver hands
+// tuples back as slices, or as maps for named tuples.
+func (t *Type) tupleValues(rv reflect.Value) ([]reflect.Value, error) {
+	values := make([]reflect.Value, len(t.Fields))
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		if rv.Len() != len(t.Fields) {
+			return nil, fmt.Errorf("tuple has %d elements, expected %d", rv.Len(), len(t.Fields))
+		}
+		for i := range t.Fields {
+			values[i] = rv.Index(i)
+		}
+	case reflect.Map:
+		for i, f := range t.Fields {
+			values[i] = rv.MapIndex(reflect.ValueOf(f.Name))
+		}
+	default:
+		return nil, fmt.Errorf("cannot encode %s as %s", rv.Type(), t)
+	}
+	return values, nil
+}
+
+func escapeQuoted(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s)
+}
+
+// jsonValue converts rv to a value encoding/json renders with the right
+// JSON types: numbers stay numbers and composites become arrays and objects.
+func (t *Type) jsonValue(rv reflect.Value) (interface{}, error) {
+	rv = indirect(rv)
+	if !rv.IsValid() {
+		return nil, nil
+	}
+
+	switch t.Kind {
+	case Array, Nested:
+		elem := t.Elem
+		if t.Kind == Nested {
+			elem = t.nestedTuple()
+		}
+		out := make([]interface{}, rv.Len())
+		for i := range out {
+			v, err := elem.jsonValue(rv.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			out[i] = v
+		}
+		return out, nil
+	case Map:
+		entries, err := t.mapEntries(rv, func(kt *Type, k reflect.Value) (string, error) {
+			return kt.Encode(k.Interface())
+		})
+		if err != nil {
+			return nil, err
+		}
+		out := make(map[string]interface{}, len(entries))
+		for _, e := range entries {
+			v, err := t.Value.jsonValue(e.value)
+			if err != nil {
+				return nil, err
+			}
+			out[e.key] = v
+		}
+		return out, nil
+	case Tuple:
+		values, err := t.tupleValues(rv)
+		if err != nil {
+			return nil, err
+		}
+		if t.named() {
+			out := make(map[string]interface{}, len(values))
+			for i, f := range t.Fields {
+				v, err := f.Type.jsonValue(values[i])
+				if err != nil {
+					return nil, err
+				}
+				out[f.Name] = v
+			}
+			return out, nil
+		}
+		out := make([]interface{}, len(values))
+		for i, f := range t.Fields {
+			v, err := f.Type.jsonValue(values[i])
+			if err != nil {
+				return nil, err
+			}
+			out[i] = v
+		}
+		return out, nil
+	}
+
+	text, err := t.encodeValue(rv.Interface())
+	if err != nil {
+		return nil, err
+	}
+	switch t.Kind {
+	case Bool:
+		return rv.Bool(), nil
+	case Int8, Int16, Int32, Int64, Int128, Int256, UInt8, UInt16, UInt32, UInt64, UInt128, UInt256, Decimal:
+		return json.Number(text), nil
+	case Float32, Float64:
+		if _, err := strconv.ParseFloat(text, 64); err == nil && text != "inf" && text != "-inf" && text != "nan" {
+			return json.Number(text), nil
+		}
+	}
+	return text, nil
+}
+
+// JSONValue converts a scanned value to a JSON-friendly value.
+func (t *Type) JSONValue(v interface{}) (interface{}, error) {
+	return t.jsonValue(reflect.ValueOf(v))
+}
+
+func (t *Type) encodeComposite(rv reflect.Value, format CompositeFormat) (string, error) {
+	if format == FormatJSON {
+		v, err := t.jsonValue(rv)
+		if err != nil {
+			return "", err
+		}
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(v); err != nil {
+			return "", err
+		}
+		return strings.TrimSuffix(buf.String(), "\n"), nil
+	}
+	var b strings.Builder
+	if err := t.writeLiteral(&b, rv); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+func (t *Type) decodeComposite(s string, format CompositeFormat) (interface{}, error) {
+	var (
+		rv  reflect.Value
+		err error
+	)
+	if format == FormatJSON {
+		dec := json.NewDecoder(strings.NewReader(s))
+		dec.UseNumber()
+		var v interface{}
+		if err := dec.Decode(&v); err != nil {
+			return nil, fmt.Errorf("invalid JSON: %v", err)
+		}
+		rv, err = t.fromJSON(v)
+	} else {
+		p := &literalParser{s: s}
+		if rv, err = p.parseValue(t); err == nil {
+			p.skipSpace()
+			if p.pos < len(p.s) {
+				err = p.errorf("unexpected trailing input")
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return rv.Interface(), nil
+}
+
+// goValue converts a value returned by Decode to t's Go type, so it can be
+// stored in typed slices and maps.
+func (t *Type) goValue(v interface{}) (reflect.Value, error) {
+	typ, err := t.GoType()
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	if v == nil {
+		return reflect.Zero(typ), nil
+	}
+	base := typ
+	if t.Nullable {
+		base = typ.Elem()
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Type() == reflect.PointerTo(base) {
+		rv = rv.Elem()
+	}
+	if !rv.Type().AssignableTo(base) {
+		if !rv.CanConvert(base) {
+			return reflect.Value{}, fmt.Errorf("cannot use %T as %s", v, t)
+		}
+		rv = rv.Convert(base)
+	}
+	if t.Nullable {
+		ptr := reflect.New(base)
+		ptr.Elem().Set(rv)
+		return ptr, nil
+	}
+	return rv, nil
+}
+
+// fromJSON converts a value decoded by encoding/json (with UseNumber) to t's Go type.
+func (t *Type) fromJSON(v interface{}) (reflect.Value, error) {
+	if v == nil {
+		if t.IsComposite() || t.Nullable {
+			return t.goValue(nil)
+		}
+		return reflect.Value{}, fmt.Errorf("null is not allowed for %s", t)
+	}
+
+	switch t.Kind {
+	case Array, Nested:
+		items, ok := v.([]interface{})
+		if !ok {
+			return reflect.Value{}, fmt.Errorf("expected JSON array for %s", t)
+		}
+		elem := t.Elem
+		if t.Kind == Nested {
+			elem = t.nestedTuple()
+		}
+		typ, err := t.GoType()
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		out := reflect.MakeSlice(typ, 0, len(items))
+		for _, item := range items {
+			ev, err := elem.fromJSON(item)
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			out = reflect.Append(out, ev)
+		}
+		return out, nil
+	case Map:
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return reflect.Value{}, fmt.Errorf("expected JSON object for %s", t)
+		}
+		typ, err := t.GoType()
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		out := reflect.MakeMapWithSize(typ, len(obj))
+		for k, item := range obj {
+			key, err := t.Key.Decode(k)
+			if err != nil {
+				return reflect.Value{}, fmt.Errorf("invalid map key %q: %v", k, err)
+			}
+			kv, err := t.Key.goValue(key)
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			vv, err := t.Value.fromJSON(item)
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			out.SetMapIndex(kv, vv)
+		}
+		return out, nil
+	case Tuple:
+		values := make([]interface{}, len(t.Fields))
+		switch items := v.(type) {
+		case []interface{}:
+			if len(items) != len(t.Fields) {
+				return reflect.Value{}, fmt.Errorf("tuple has %d elements, expected %d", len(items), len(t.Fields))
+			}
+			for i, f := range t.Fields {
+				fv, err := f.Type.fromJSON(items[i])
+				if err != nil {
+					return reflect.Value{}, err
+				}
+				values[i] = fv.Interface()
+			}
+		case map[string]interface{}:
+			for i, f := range t.Fields {
+				fv, err := f.Type.fromJSON(items[f.Name])
+				if err != nil {
+					return reflect.Value{}, fmt.Errorf("tuple element %s: %v", f.Name, err)
+				}
+				values[i] = fv.Interface()
+			}
+		default:
+			return reflect.Value{}, fmt.Errorf("expected JSON array or object for %s", t)
+		}
+		return reflect.ValueOf(values), nil
+	}
+
+	var (
+		decoded interface{}
+		err     error
+	)
+	switch val := v.(type) {
+	case json.Number:
+		decoded, err = t.Decode(val.String())
+	case string:
+		decoded, err = t.decodeQuoted(val)
+	case bool:
+		decoded, err = t.Decode(strconv.FormatBool(val))
+	default:
+		return reflect.Value{}, fmt.Errorf("unexpected JSON value %v for %s", v, t)
+	}
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	return t.goValue(decoded)
+}
+
+// literalParser reads ClickHouse literals such as [('a',1),('b',NULL)].
+type literalParser struct {
+	s   string
+	pos int
+}
+
+func (p *literalParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%s at offset %d", fmt.Sprintf(format, args...), p.pos)
+}
+
+func (p *literalParser) skipSpace() {
+	for p.pos < len(p.s) && (p.s[p.pos] == ' ' || p.s[p.pos] == '\t' || p.s[p.pos] == '\n' || p.s[p.pos] == '\r') {
+		p.pos++
+	}
+}
+
+func (p *literalParser) peek() byte {
+	p.skipSpace()
+	if p.pos >= len(p.s) {
+		return 0
+	}
+	return p.s[p.pos]
+}
+
+func (p *literalParser) expect(c byte) error {
+	if p.peek() != c {
+		return p.errorf("expected %q", c)
+	}
+	p.pos++
+	return nil
+}
+
+// list parses "open elem, elem close", calling elem for each element.
+func (p *literalParser) list(open, close byte, elem func() error) error {
+	if err := p.expect(open); err != nil {
+		return err
+	}
+	if p.peek() == close {
+		p.pos++
+		return nil
+	}
+	for {
+		if err := elem(); err != nil {
+			return err
+		}
+		switch p.peek() {
+		case ',':
+			p.pos++
+		case close:
+			p.pos++
+			return nil
+		default:
+			return p.errorf("expected ',' or %q", close)
+		}
+	}
+}
+
+func (p *literalParser) parseValue(t *Type) (reflect.Value, error) {
+	if p.peek() == 0 {
+		return reflect.Value{}, p.errorf("unexpected end of input")
+	}
+
+	switch t.Kind {
+	case Array, Nested:
+		elem := t.Elem
+		if t.Kind == Nested {
+			elem = t.nestedTuple()
+		}
+		typ, err := t.GoType()
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		out := reflect.MakeSlice(typ, 0, 0)
+		err = p.list('[', ']', func() error {
+			ev, err := p.parseValue(elem)
+			if err == nil {
+				out = reflect.Append(out, ev)
+			}
+			return err
+		})
+		return out, err
+	case Map:
+		typ, err := t.GoType()
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		out := reflect.MakeMap(typ)
+		err = p.list('{', '}', func() error {
+			kv, err := p.parseValue(t.Key)
+			if err != nil {
+				return err
+			}
+			if err := p.expect(':'); err != nil {
+				return err
+			}
+			vv, err := p.parseValue(t.Value)
+			if err == nil {
+				out.SetMapIndex(kv, vv)
+			}
+			return err
+		})
+		return out, err
+	case Tuple:
+		values := make([]interface{}, 0, len(t.Fields))
+		err := p.list('(', ')', func() error {
+			if len(values) == len(t.Fields) {
+				return p.errorf("too many tuple elements")
+			}
+			fv, err := p.parseValue(t.Fields[len(values)].Type)
+			if err == nil {
+				values = append(values, fv.Interface())
+			}
+			return err
+		})
+		if err == nil && len(values) != len(t.Fields) {
+			err = p.errorf("tuple has %d elements, expected %d", len(values), len(t.Fields))
+		}
+		return reflect.ValueOf(values), err
+	}
+
+	var (
+		text   string
+		quoted bool
+	)
+	switch c := p.peek(); c {
+	case '\'', '"':
+		s, err := p.readQuoted(c)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		text, quoted = s, true
+	default:
+		if text = p.readBare(); text == "" {
+			return reflect.Value{}, p.errorf("expected a value")
+		}
+	}
+	if !quoted && t.Nullable && strings.EqualFold(text, "NULL") {
+		return t.goValue(nil)
+	}
+	decode := t.Decode
+	if quoted {
+		decode = t.decodeQuoted
+	}
+	decoded, err := decode(text)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	return t.goValue(decoded)
+}
+
+// decodeQuoted decodes a quoted element of a composite value. Unlike a CSV
+// field, a quoted string is never NULL, so the string \N reads as itself.
+func (t *Type) decodeQuoted(s string) (interface{}, error) {
+	if t.Nullable && (t.Kind == String || t.Kind == FixedString) {
+		base := *t
+		base.Nullable = false
+		return base.Decode(s)
+	}
+	return t.Decode(s)
+}
+
+func (p *literalParser) readQuoted(quote byte) (string, error) {
+	p.pos++
+	var b strings.Builder
+	for p.pos < len(p.s) {
+		c := p.s[p.pos]
+		switch {
+		case c == '\\' && p.pos+1 < len(p.s):
+			p.pos++
+			switch e := p.s[p.pos]; e {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'r':
+				b.WriteByte('\r')
+			case '0':
+				b.WriteByte(0)
+			default:
+				b.WriteByte(e)
+			}
+		case c == quote:
+			p.pos++
+			return b.String(), nil
+		default:
+			b.WriteByte(c)
+		}
+		p.pos++
+	}
+	return "", p.errorf("unterminated string")
+}
+
+func (p *literalParser) readBare() string {
+	start := p.pos
+	for p.pos < len(p.s) && !strings.ContainsRune(",:[]{}() \t\r\n", rune(p.s[p.pos])) {
+		p.pos++
+	}
+	return p.s[start:p.pos]
+}
@@ -0,0 +1,201 @@
+package chtypes
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func ptr[T any](v T) *T { return &v }
+
+func TestCompositeRoundTrip(t *testing.T) {
+	tests := []struct {
+		name       string
+		typ        string
+		value      interface{}
+		clickhouse string
+		json       string
+	}{
+		{
+			name:       "empty array",
+			typ:        "Array(String)",
+			value:      []string{},
+			clickhouse: `[]`,
+			json:       `[]`,
+		},
+		{
+			name:       "quoting and escaping",
+			typ:        "Array(String)",
+			value:      []string{"it's", `back\slash`, "comma,here", "tab\tand\nnewline", `"double"`, "<html>&"},
+			clickhouse: `['it\'s','back\\slash','comma,here','tab` + "\t" + `and` + "\n" + `newline','"double"','<html>&']`,
+			json:       `["it's","back\\slash","comma,here","tab\tand\nnewline","\"double\"","<html>&"]`,
+		},
+		{
+			name:       "null elements",
+			typ:        "Array(Nullable(Int32))",
+			value:      []*int32{ptr[int32](1), nil, ptr[int32](-3)},
+			clickhouse: `[1,NULL,-3]`,
+			json:       `[1,null,-3]`,
+		},
+		{
+			name:       "null strings",
+			typ:        "Array(Nullable(String))",
+			value:      []*string{ptr("NULL"), nil, ptr(""), ptr(`\N`)},
+			clickhouse: `['NULL',NULL,'','\\N']`,
+			json:       `["NULL",null,"","\\N"]`,
+		},
+		{
+			name:       "nested arrays",
+			typ:        "Array(Array(Int8))",
+			value:      [][]int8{{1, 2}, {}, {-3}},
+			clickhouse: `[[1,2],[],[-3]]`,
+			json:       `[[1,2],[],[-3]]`,
+		},
+		{
+			name:       "map",
+			typ:        "Map(String, UInt64)",
+			value:      map[string]uint64{"b": 2, "a'1": 1},
+			clickhouse: `{'a\'1':1,'b':2}`,
+			json:       `{"a'1":1,"b":2}`,
+		},
+		{
+			name:       "map of arrays",
+			typ:        "Map(String, Array(Nullable(Float64)))",
+			value:      map[string][]*float64{"x": {ptr(1.5), nil}, "y": {}},
+			clickhouse: `{'x':[1.5,NULL],'y':[]}`,
+			json:       `{"x":[1.5,null],"y":[]}`,
+		},
+		{
+			name:       "map with integer keys",
+			typ:        "Map(UInt8, String)",
+			value:      map[uint8]string{7: "seven", 10: "ten"},
+			clickhouse: `{10:'ten',7:'seven'}`,
+			json:       `{"10":"ten","7":"seven"}`,
+		},
+		{
+			name:       "tuple",
+			typ:        "Tuple(String, Int64, Nullable(String))",
+			value:      []interface{}{"a,b", int64(42), (*string)(nil)},
+			clickhouse: `('a,b',42,NULL)`,
+			json:       `["a,b",42,null]`,
+		},
+		{
+			name:       "named tuple",
+			typ:        "Tuple(name String, scores Array(UInt16))",
+			value:      []interface{}{"o'neil", []uint16{3, 4}},
+			clickhouse: `('o\'neil',[3,4])`,
+			json:       `{"name":"o'neil","scores":[3,4]}`,
+		},
+		{
+			name:       "nested",
+			typ:        "Nested(id UInt32, tag Nullable(String))",
+			value:      [][]interface{}{{uint32(1), ptr("x")}, {uint32(2), (*string)(nil)}},
+			clickhouse: `[(1,'x'),(2,NULL)]`,
+			json:       `[{"id":1,"tag":"x"},{"id":2,"tag":null}]`,
+		},
+		{
+			name:       "array of tuples with maps",
+			typ:        "Array(Tuple(Map(String, String), Bool))",
+			value:      [][]interface{}{{map[string]string{"k": "[v]"}, true}},
+			clickhouse: `[({'k':'[v]'},true)]`,
+			json:       `[[{"k":"[v]"},true]]`,
+		},
+	}
+
+	for _, tt := range tests {
+		typ, err := Parse(tt.typ)
+		if err != nil {
+			t.Fatalf("%s: Parse(%q): %v", tt.name, tt.typ, err)
+		}
+		for _, c := range []struct {
+			format CompositeFormat
+			want   string
+		}{
+			{FormatClickHouse, tt.clickhouse},
+			{FormatJSON, tt.json},
+		} {
+			got, err := typ.EncodeWith(tt.value, c.format)
+			if err != nil {
+				t.Errorf("%s: EncodeWith(%s): %v", tt.name, c.format, err)
+				continue
+			}
+			if got != c.want {
+				t.Errorf("%s: EncodeWith(%s) = %s, want %s", tt.name, c.format, got, c.want)
+			}
+			decoded, err := typ.DecodeWith(c.want, c.format)
+			if err != nil {
+				t.Errorf("%s: DecodeWith(%s, %s): %v", tt.name, c.want, c.format, err)
+				continue
+			}
+			if !reflect.DeepEqual(decoded, tt.value) {
+				t.Errorf("%s: DecodeWith(%s, %s) = %#v, want %#v", tt.name, c.want, c.format, decoded, tt.value)
+			}
+		}
+	}
+}
+
+func TestDecodeCompositeLiterals(t *testing.T) {
+	tests := []struct {
+		typ    string
+		format CompositeFormat
+		input  string
+		want   interface{}
+	}{
+		{"Array(String)", FormatClickHouse, ` [ 'a' , "b\"c" ] `, []string{"a", `b"c`}},
+		{"Array(String)", FormatClickHouse, `['line\nbreak','tab\t','nul\0']`, []string{"line\nbreak", "tab\t", "nul\x00"}},
+		{"Array(Nullable(String))", FormatClickHouse, `[null,'null',NULL]`, []*string{nil, ptr("null"), nil}},
+		{"Array(Nullable(UInt8))", FormatClickHouse, `[1, Null]`, []*uint8{ptr[uint8](1), nil}},
+		{"Map(String, Int32)", FormatClickHouse, `{ 'a' : 1 , 'b':-2 }`, map[string]int32{"a": 1, "b": -2}},
+		{"Tuple(a String, b Int8)", FormatJSON, `["x", 1]`, []interface{}{"x", int8(1)}},
+		{"Tuple(a String, b Nullable(Int8))", FormatJSON, `{"a":"x"}`, []interface{}{"x", (*int8)(nil)}},
+		{"Map(String, Array(String))", FormatJSON, `{"k":null}`, map[string][]string{"k": nil}},
+		{"Array(Date)", FormatJSON, `["2026-01-02"]`, []time.Time{time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC)}},
+	}
+	for _, tt := range tests {
+		typ, err := Parse(tt.typ)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tt.typ, err)
+		}
+		got, err := typ.DecodeWith(tt.input, tt.format)
+		if err != nil {
+			t.Errorf("%s: DecodeWith(%s, %s): %v", tt.typ, tt.input, tt.format, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: DecodeWith(%s, %s) = %#v, want %#v", tt.typ, tt.input, tt.format, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeCompositeErrors(t *testing.T) {
+	tests := []struct {
+		typ    string
+		format CompositeFormat
+		input  string
+	}{
+		{"Array(String)", FormatClickHouse, `['a'`},
+		{"Array(String)", FormatClickHouse, `['a' 'b']`},
+		{"Array(String)", FormatClickHouse, `['unterminated]`},
+		{"Array(String)", FormatClickHouse, `['a'] trailing`},
+		{"Array(Int32)", FormatClickHouse, `[NULL]`},
+		{"Array(UInt8)", FormatClickHouse, `[256]`},
+		{"Map(String, Int32)", FormatClickHouse, `{'a' 1}`},
+		{"Tuple(String, Int32)", FormatClickHouse, `('a')`},
+		{"Tuple(String, Int32)", FormatClickHouse, `('a',1,2)`},
+		{"Array(String)", FormatJSON, `{"a":1}`},
+		{"Array(Int32)", FormatJSON, `[null]`},
+		{"Map(String, Int32)", FormatJSON, `[1]`},
+		{"Map(UInt8, Int32)", FormatJSON, `{"x":1}`},
+		{"Tuple(String, Int32)", FormatJSON, `["a"]`},
+		{"Array(String)", FormatJSON, `["a"`},
+	}
+	for _, tt := range tests {
+		typ, err := Parse(tt.typ)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tt.typ, err)
+		}
+		if got, err := typ.DecodeWith(tt.input, tt.format); err == nil {
+			t.Errorf("%s: DecodeWith(%s, %s) = %#v, want an error", tt.typ, tt.input, tt.format, got)
+		}
+	}
+}
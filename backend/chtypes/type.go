@@ -0,0 +1,427 @@
+// Package chtypes models ClickHouse column types and converts values between
+// their CSV text form and the Go values used by clickhouse-go.
+package chtypes
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Kind int
+
+const (
+	Unknown Kind = iota
+	Int8
+	Int16
+	Int32
+	Int64
+	Int128
+	Int256
+	UInt8
+	UInt16
+	UInt32
+	UInt64
+	UInt128
+	UInt256
+	Float32
+	Float64
+	Decimal
+	Bool
+	String
+	FixedString
+	UUID
+	Date
+	Date32
+	DateTime
+	DateTime64
+	IPv4
+	IPv6
+	Enum8
+	Enum16
+	Array
+	Map
+	Tuple
+	Nested
+)
+
+var kindNames = map[string]Kind{
+	"Int8":        Int8,
+	"Int16":       Int16,
+	"Int32":       Int32,
+	"Int64":       Int64,
+	"Int128":      Int128,
+	"Int256":      Int256,
+	"UInt8":       UInt8,
+	"UInt16":      UInt16,
+	"UInt32":      UInt32,
+	"UInt64":      UInt64,
+	"UInt128":     UInt128,
+	"UInt256":     UInt256,
+	"Float32":     Float32,
+	"Float64":     Float64,
+	"Decimal":     Decimal,
+	"Bool":        Bool,
+	"String":      String,
+	"FixedString": FixedString,
+	"UUID":        UUID,
+	"Date":        Date,
+	"Date32":      Date32,
+	"DateTime":    DateTime,
+	"DateTime64":  DateTime64,
+	"IPv4":        IPv4,
+	"IPv6":        IPv6,
+	"Enum8":       Enum8,
+	"Enum16":      Enum16,
+	"Array":       Array,
+	"Map":         Map,
+	"Tuple":       Tuple,
+	"Nested":      Nested,
+}
+
+// aliases maps alternative spellings accepted by ClickHouse to canonical names.
+var aliases = map[string]string{
+	"Boolean": "Bool",
+	"TINYINT": "Int8",
+	"BIGINT":  "Int64",
+	"INT":     "Int32",
+	"DOUBLE":  "Float64",
+	"FLOAT":   "Float32",
+	"TEXT":    "String",
+	"VARCHAR": "String",
+}
+
+// decimalPrecision holds the implied precision of the DecimalN(S) shorthands.
+var decimalPrecision = map[string]int{
+	"Decimal32":  9,
+	"Decimal64":  18,
+	"Decimal128": 38,
+	"Decimal256": 76,
+}
+
+// Field is a member of a Tuple or Nested type. Name is empty for unnamed
+// tuple elements.
+type Field struct {
+	Name string
+	Type *Type
+}
+
+// Type is a parsed ClickHouse column type. Nullable and LowCardinality wrappers
+// are folded into flags on the wrapped type.
+type Type struct {
+	Kind           Kind
+	Name           string // base type name, e.g. "Decimal" or an unrecognised name
+	Nullable       bool
+	LowCardinality bool
+
+	Precision int    // Decimal precision, DateTime64 sub-second digits
+	Scale     int    // Decimal scale
+	Length    int    // FixedString length
+	Timezone  string // DateTime/DateTime64 explicit timezone
+	Params    []string
+
+	Elem   *Type   // Array element type
+	Key    *Type   // Map key type
+	Value  *Type   // Map value type
+	Fields []Field // Tuple and Nested members
+
+	location    *time.Location
+	defaultZone *time.Location
+}
+
+// Parse parses a ClickHouse type string such as
+// "LowCardinality(Nullable(String))" or "DateTime64(3, 'UTC')".
+// Types the package does not understand are returned with Kind Unknown.
+func Parse(s string) (*Type, error) {
+	name, args, err := splitType(strings.TrimSpace(s))
+	if err != nil {
+		return nil, err
+	}
+
+	switch name {
+	case "Nullable", "LowCardinality":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("%s expects one argument: %q", name, s)
+		}
+		inner, err := Parse(args[0])
+		if err != nil {
+			return nil, err
+		}
+		if name == "Nullable" {
+			inner.Nullable = true
+		} else {
+			inner.LowCardinality = true
+		}
+		return inner, nil
+	case "SimpleAggregateFunction":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("SimpleAggregateFunction expects two arguments: %q", s)
+		}
+		return Parse(args[1])
+	}
+
+	if canonical, ok := aliases[name]; ok {
+		name = canonical
+	}
+	t := &Type{Name: name, Params: args}
+	if p, ok := decimalPrecision[name]; ok {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("%s expects a scale: %q", name, s)
+		}
+		t.Kind, t.Name, t.Precision = Decimal, "Decimal", p
+		if t.Scale, err = strconv.Atoi(args[0]); err != nil {
+			return nil, fmt.Errorf("invalid scale in %q", s)
+		}
+		return t, nil
+	}
+	t.Kind = kindNames[name]
+
+	switch t.Kind {
+	case Decimal:
+		if len(args) < 1 || len(args) > 2 {
+			return nil, fmt.Errorf("Decimal expects precision and scale: %q", s)
+		}
+		if t.Precision, err = strconv.Atoi(args[0]); err != nil {
+			return nil, fmt.Errorf("invalid precision in %q", s)
+		}
+		if len(args) == 2 {
+			if t.Scale, err = strconv.Atoi(args[1]); err != nil {
+				return nil, fmt.Errorf("invalid scale in %q", s)
+			}
+		}
+	case FixedString:
+		if len(args) != 1 {
+			return nil, fmt.Errorf("FixedString expects a length: %q", s)
+		}
+		if t.Length, err = strconv.Atoi(args[0]); err != nil {
+			return nil, fmt.Errorf("invalid length in %q", s)
+		}
+	case Array:
+		if len(args) != 1 {
+			return nil, fmt.Errorf("Array expects one argument: %q", s)
+		}
+		if t.Elem, err = Parse(args[0]); err != nil {
+			return nil, err
+		}
+	case Map:
+		if len(args) != 2 {
+			return nil, fmt.Errorf("Map expects key and value types: %q", s)
+		}
+		if t.Key, err = Parse(args[0]); err != nil {
+			return nil, err
+		}
+		if t.Value, err = Parse(args[1]); err != nil {
+			return nil, err
+		}
+	case Tuple, Nested:
+		if len(args) == 0 {
+			return nil, fmt.Errorf("%s expects at least one element: %q", name, s)
+		}
+		for _, arg := range args {
+			field, err := parseField(arg)
+			if err != nil {
+				return nil, err
+			}
+			if t.Kind == Nested && field.Name == "" {
+				return nil, fmt.Errorf("Nested elements must be named: %q", s)
+			}
+			t.Fields = append(t.Fields, field)
+		}
+	case DateTime:
+		if len(args) == 1 {
+			t.Timezone = unquote(args[0])
+		}
+	case DateTime64:
+		if len(args) < 1 {
+			return nil, fmt.Errorf("DateTime64 expects a precision: %q", s)
+		}
+		if t.Precision, err = strconv.Atoi(args[0]); err != nil {
+			return nil, fmt.Errorf("invalid precision in %q", s)
+		}
+		if len(args) == 2 {
+			t.Timezone = unquote(args[1])
+		}
+	}
+
+	if t.Timezone != "" {
+		if t.location, err = time.LoadLocation(t.Timezone); err != nil {
+			return nil, fmt.Errorf("unknown timezone in %q: %v", s, err)
+		}
+	}
+	return t, nil
+}
+
+// parseField parses a tuple element, either "Type" or "name Type".
+func parseField(arg string) (Field, error) {
+	depth := 0
+	for i := 0; i < len(arg); i++ {
+		switch arg[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ' ':
+			if depth == 0 {
+				typ, err := Parse(arg[i+1:])
+				if err != nil {
+					return Field{}, err
+				}
+				return Field{Name: strings.Trim(arg[:i], "`\""), Type: typ}, nil
+			}
+		}
+	}
+	typ, err := Parse(arg)
+	if err != nil {
+		return Field{}, err
+	}
+	return Field{Type: typ}, nil
+}
+
+// IsComposite reports whether t holds nested values.
+func (t *Type) IsComposite() bool {
+	switch t.Kind {
+	case Array, Map, Tuple, Nested:
+		return true
+	}
+	return false
+}
+
+// named reports whether every tuple element has a name.
+func (t *Type) named() bool {
+	for _, f := range t.Fields {
+		if f.Name == "" {
+			return false
+		}
+	}
+	return len(t.Fields) > 0
+}
+
+// SetDefaultLocation sets the timezone used for DateTime values without an
+// explicit zone, normally the server timezone. It applies to nested types too.
+func (t *Type) SetDefaultLocation(loc *time.Location) {
+	t.defaultZone = loc
+	for _, sub := range []*Type{t.Elem, t.Key, t.Value} {
+		if sub != nil {
+			sub.SetDefaultLocation(loc)
+		}
+	}
+	for _, f := range t.Fields {
+		f.Type.SetDefaultLocation(loc)
+	}
+}
+
+// Location returns the timezone DateTime values of this type are read in.
+func (t *Type) Location() *time.Location {
+	switch {
+	case t.location != nil:
+		return t.location
+	case t.defaultZone != nil:
+		return t.defaultZone
+	}
+	return time.UTC
+}
+
+// String returns the type in ClickHouse syntax.
+func (t *Type) String() string {
+	s := t.baseString()
+	if t.Nullable {
+		s = "Nullable(" + s + ")"
+	}
+	if t.LowCardinality {
+		s = "LowCardinality(" + s + ")"
+	}
+	return s
+}
+
+func (t *Type) baseString() string {
+	switch t.Kind {
+	case Array:
+		return "Array(" + t.Elem.String() + ")"
+	case Map:
+		return "Map(" + t.Key.String() + ", " + t.Value.String() + ")"
+	case Tuple, Nested:
+		fields := make([]string, len(t.Fields))
+		for i, f := range t.Fields {
+			fields[i] = f.Type.String()
+			if f.Name != "" {
+				fields[i] = f.Name + " " + fields[i]
+			}
+		}
+		return t.Name + "(" + strings.Join(fields, ", ") + ")"
+	case Decimal:
+		return fmt.Sprintf("Decimal(%d, %d)", t.Precision, t.Scale)
+	case FixedString:
+		return fmt.Sprintf("FixedString(%d)", t.Length)
+	case DateTime:
+		if t.Timezone != "" {
+			return fmt.Sprintf("DateTime('%s')", t.Timezone)
+		}
+	case DateTime64:
+		if t.Timezone != "" {
+			return fmt.Sprintf("DateTime64(%d, '%s')", t.Precision, t.Timezone)
+		}
+		return fmt.Sprintf("DateTime64(%d)", t.Precision)
+	}
+	if len(t.Params) > 0 {
+		return t.Name + "(" + strings.Join(t.Params, ", ") + ")"
+	}
+	return t.Name
+}
+
+// splitType splits "Name(arg1, arg2)" into its name and top-level arguments.
+func splitType(s string) (string, []string, error) {
+	open := strings.IndexByte(s, '(')
+	if open < 0 {
+		if s == "" {
+			return "", nil, fmt.Errorf("empty type")
+		}
+		return s, nil, nil
+	}
+	if !strings.HasSuffix(s, ")") {
+		return "", nil, fmt.Errorf("unbalanced parentheses in type %q", s)
+	}
+	name := strings.TrimSpace(s[:open])
+	body := s[open+1 : len(s)-1]
+
+	var args []string
+	depth, start := 0, 0
+	inQuote := false
+	for i := 0; i < len(body); i++ {
+		switch ch := body[i]; {
+		case inQuote:
+			if ch == '\\' {
+				i++
+			} else if ch == '\'' {
+				inQuote = false
+			}
+		case ch == '\'':
+			inQuote = true
+		case ch == '(':
+			depth++
+		case ch == ')':
+			depth--
+			if depth < 0 {
+				return "", nil, fmt.Errorf("unbalanced parentheses in type %q", s)
+			}
+		case ch == ',' && depth == 0:
+			args = append(args, strings.TrimSpace(body[start:i]))
+			start = i + 1
+		}
+	}
+	if depth != 0 || inQuote {
+		return "", nil, fmt.Errorf("unbalanced type expression %q", s)
+	}
+	if rest := strings.TrimSpace(body[start:]); rest != "" || len(args) > 0 {
+		args = append(args, rest)
+	}
+	return name, args, nil
+}
+
+func unquote(s string) string {
+	s = strings.TrimSpace(s)
+	if len(s) >= 2 && s[0] == '\'' && s[len(s)-1] == '\'' {
+		s = s[1 : len(s)-1]
+	}
+	return strings.ReplaceAll(s, `\'`, `'`)
+}
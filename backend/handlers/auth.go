@@ -1,28 +1,103 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 
+	"github.com/AditiKulkarni9/clickhouse-flatfile-tool/services"
 	"github.com/AditiKulkarni9/clickhouse-flatfile-tool/utils"
 	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
 )
 
-// GenerateJWTToken generates a new JWT token for the given username
+// tokens issues and checks API tokens; it is configured by SetTokenService
+var tokens = services.NewTokenService(denyAll{}, 0, 0)
+
+// denyAll rejects every login until a real authenticator is configured
+type denyAll struct{}
+
+func (denyAll) Authenticate(username, password string) error {
+	return services.ErrInvalidCredentials
+}
+
+func (denyAll) UserExists(username string) (bool, error) {
+	return false, nil
+}
+
+// SetTokenService sets the service used for login, refresh and token checks
+func SetTokenService(s *services.TokenService) {
+	tokens = s
+}
+
+// GenerateJWTToken logs a user in and returns an access and a refresh token
 func GenerateJWTToken(c *gin.Context) {
 	var req struct {
-		Username string `json:"username"`
+		Username string `json:"username" binding:"required"`
+		Password string `json:"password" binding:"required"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Generate a token that expires in 24 hours
-	token, err := utils.GenerateToken(req.Username, 24)
+	pair, err := tokens.Login(req.Username, req.Password)
+	if errors.Is(err, services.ErrInvalidCredentials) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"token": token})
+	c.JSON(http.StatusOK, pair)
+}
+
+// RefreshJWTToken exchanges a refresh token for a new token pair
+func RefreshJWTToken(c *gin.Context) {
+	var req struct {
+		RefreshToken string `json:"refreshToken" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	pair, err := tokens.Refresh(req.RefreshToken)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, pair)
+}
+
+// Logout revokes the caller's access token and, if given, their refresh token
+func Logout(c *gin.Context) {
+	var req struct {
+		RefreshToken string `json:"refreshToken"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	if req.RefreshToken != "" {
+		if err := tokens.RevokeOwn(c.GetString(userContextKey), req.RefreshToken); err != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if claims, ok := c.Get(claimsContextKey); ok {
+		tokens.RevokeClaims(claims.(jwt.MapClaims))
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out"})
+}
+
+// JWKS publishes the public token verification keys
+func JWKS(c *gin.Context) {
+	c.JSON(http.StatusOK, utils.Keys().JWKS())
 }
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/AditiKulkarni9/clickhouse-flatfile-tool/services"
+	"github.com/gin-gonic/gin"
+)
+
+// uploadOffsetHeader carries the byte offset of a chunk in requests and the
+// current offset of the upload in responses.
+const uploadOffsetHeader = "Upload-Offset"
+
+// StartChunkedUpload begins a resumable upload. The client then sends the
+// file in chunks with PUT /uploads/chunked/:id and finishes with
+// POST /uploads/chunked/:id/complete.
+func StartChunkedUpload(c *gin.Context) {
+	var req struct {
+		Filename string `json:"filename" binding:"required"`
+		Size     int64  `json:"size" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	partial, err := uploadStore.StartChunked(c.GetString(sessionContextKey), c.GetString(userContextKey), req.Filename, req.Size)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.Header("Location", "/uploads/chunked/"+partial.ID)
+	c.Header(uploadOffsetHeader, "0")
+	c.JSON(http.StatusCreated, partial)
+}
+
+// GetChunkedUpload reports how much of an upload has been received, so an
+// interrupted client knows where to continue.
+func GetChunkedUpload(c *gin.Context) {
+	partial, err := uploadStore.GetChunked(c.GetString(sessionContextKey), c.Param("id"))
+	if !chunkedUploadFound(c, err) {
+		return
+	}
+	c.Header(uploadOffsetHeader, strconv.FormatInt(partial.Offset, 10))
+	c.JSON(http.StatusOK, partial)
+}
+
+// PutUploadChunk appends the request body to an upload. The offset comes
+// from the Upload-Offset header or the offset query parameter and must match
+// the current offset of the upload.
+func PutUploadChunk(c *gin.Context) {
+	raw := c.GetHeader(uploadOffsetHeader)
+	if raw == "" {
+		raw = c.Query("offset")
+	}
+	offset, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing or invalid chunk offset"})
+		return
+	}
+
+	partial, err := uploadStore.WriteChunk(c.GetString(sessionContextKey), c.Param("id"), offset, c.Request.Body)
+	if partial != nil {
+		c.Header(uploadOffsetHeader, strconv.FormatInt(partial.Offset, 10))
+	}
+	switch {
+	case errors.Is(err, services.ErrOffsetMismatch):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "offset": partial.Offset})
+		return
+	case errors.Is(err, services.ErrChunkTooLarge):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error(), "offset": partial.Offset})
+		return
+	case !chunkedUploadFound(c, err):
+		return
+	}
+	c.JSON(http.StatusOK, partial)
+}
+
+// CompleteChunkedUpload checks the SHA-256 checksum of a fully received
+// upload and makes it available like a regular upload.
+func CompleteChunkedUpload(c *gin.Context) {
+	var req struct {
+		SHA256 string `json:"sha256" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	upload, err := uploadStore.CompleteChunked(c.GetString(sessionContextKey), c.Param("id"), req.SHA256)
+	switch {
+	case errors.Is(err, services.ErrUploadIncomplete):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, services.ErrChecksumMismatch):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	case !chunkedUploadFound(c, err):
+		return
+	}
+	c.JSON(http.StatusCreated, upload)
+}
+
+// AbortChunkedUpload discards an unfinished upload.
+func AbortChunkedUpload(c *gin.Context) {
+	err := uploadStore.AbortChunked(c.GetString(sessionContextKey), c.Param("id"))
+	if !chunkedUploadFound(c, err) {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Upload aborted"})
+}
+
+// chunkedUploadFound writes the error response for err, if any, and reports
+// whether the handler should continue.
+func chunkedUploadFound(c *gin.Context, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, services.ErrUploadNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+	return false
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/AditiKulkarni9/clickhouse-flatfile-tool/services"
+	"github.com/gin-gonic/gin"
+)
+
+func chunkedUploadRouter(owner string) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set(sessionContextKey, owner) })
+	router.POST("/uploads/chunked", StartChunkedUpload)
+	router.GET("/uploads/chunked/:id", GetChunkedUpload)
+	router.PUT("/uploads/chunked/:id", PutUploadChunk)
+	router.POST("/uploads/chunked/:id/complete", CompleteChunkedUpload)
+	return router
+}
+
+func TestChunkedUpload(t *testing.T) {
+	dir := t.TempDir()
+	store, err := services.NewUploadStore(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetUploadStore(store)
+
+	data := "id,name\n1,a\n2,b\n"
+	sum := sha256.Sum256([]byte(data))
+	router := chunkedUploadRouter("owner")
+
+	w := httptest.NewRecorder()
+	body := `{"filename":"../data.csv","size":` + strconv.Itoa(len(data)) + `}`
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/uploads/chunked", strings.NewReader(body)))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("start: %d %s", w.Code, w.Body)
+	}
+	var partial services.PartialUpload
+	if err := json.Unmarshal(w.Body.Bytes(), &partial); err != nil {
+		t.Fatal(err)
+	}
+	path := "/uploads/chunked/" + partial.ID
+
+	steps := []struct {
+		name    string
+		method  string
+		path    string
+		offset  string // Upload-Offset request header
+		body    string
+		reopen  bool   // reopen the store first, as after a restart
+		owner   string // caller, if not the owner
+		status  int
+		current string // Upload-Offset response header
+	}{
+		{name: "first chunk", method: http.MethodPut, path: path, offset: "0", body: data[:6], status: http.StatusOK, current: "6"},
+		{name: "second chunk", method: http.MethodPut, path: path, offset: "6", body: data[6:10], status: http.StatusOK, current: "10"},
+		{name: "wrong offset", method: http.MethodPut, path: path, offset: "3", body: data[3:], status: http.StatusConflict, current: "10"},
+		{name: "missing offset", method: http.MethodPut, path: path, body: data[10:], status: http.StatusBadRequest},
+		{name: "past the size", method: http.MethodPut, path: path, offset: "10", body: data[10:] + "3,c\n", status: http.StatusRequestEntityTooLarge, current: "10"},
+		{name: "incomplete", method: http.MethodPost, path: path + "/complete", body: `{"sha256":"` + hex.EncodeToString(sum[:]) + `"}`, status: http.StatusConflict},
+		{name: "other owner", method: http.MethodGet, path: path, owner: "intruder", status: http.StatusNotFound},
+		{name: "after restart", method: http.MethodGet, path: path, reopen: true, status: http.StatusOK, current: "10"},
+		{name: "resume", method: http.MethodPut, path: path, offset: "10", body: data[10:], status: http.StatusOK, current: strconv.Itoa(len(data))},
+		{name: "bad checksum", method: http.MethodPost, path: path + "/complete", body: `{"sha256":"` + strings.Repeat("0", 64) + `"}`, status: http.StatusUnprocessableEntity},
+		{name: "good checksum", method: http.MethodPost, path: path + "/complete", body: `{"sha256":"` + strings.ToUpper(hex.EncodeToString(sum[:])) + `"}`, status: http.StatusCreated},
+		{name: "completed", method: http.MethodGet, path: path, status: http.StatusNotFound},
+	}
+	for _, step := range steps {
+		if step.reopen {
+			store, err := services.NewUploadStore(dir, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			SetUploadStore(store)
+		}
+		owner := "owner"
+		if step.owner != "" {
+			owner = step.owner
+		}
+		req := httptest.NewRequest(step.method, step.path, strings.NewReader(step.body))
+		if step.offset != "" {
+			req.Header.Set(uploadOffsetHeader, step.offset)
+		}
+		w := httptest.NewRecorder()
+		chunkedUploadRouter(owner).ServeHTTP(w, req)
+		if w.Code != step.status {
+			t.Fatalf("%s: status %d, want %d: %s", step.name, w.Code, step.status, w.Body)
+		}
+		if got := w.Header().Get(uploadOffsetHeader); got != step.current {
+			t.Errorf("%s: offset %q, want %q", step.name, got, step.current)
+		}
+	}
+
+	upload, file, err := uploadStore.Path("owner", partial.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != data || upload.OriginalName != "data.csv" || upload.SHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("completed upload %+v holds %q, want %q", upload, got, data)
+	}
+}
@@ -2,69 +2,74 @@
 package handlers
 
 import (
-	"fmt"
 	"log"
 	"net/http"
 
-	"github.com/ClickHouse/clickhouse-go/v2"
-	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+	"github.com/AditiKulkarni9/clickhouse-flatfile-tool/models"
+	"github.com/AditiKulkarni9/clickhouse-flatfile-tool/services"
 	"github.com/gin-gonic/gin"
 )
 
-var clickhouseConn driver.Conn
-
 func ConnectClickHouse(c *gin.Context) {
-	var config struct {
-		Host     string `json:"host"`
-		Port     string `json:"port"`
-		Database string `json:"database"`
-		User     string `json:"user"`
-		Password string `json:"password"`
-		JWToken  string `json:"jwtToken"`
-	}
+	var config models.ClickHouseConfig
 	if err := c.ShouldBindJSON(&config); err != nil {
 		log.Println("Error binding JSON: ", err)
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
+	if config.JWTToken != "" && !config.Secure {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "jwtToken requires a secure connection"})
+		return
+	}
+
 	log.Println("Connecting to ClickHouse...")
 	log.Printf("Host: %s, Port: %s, Database: %s, User: %s", config.Host, config.Port, config.Database, config.User)
 
-	// Create connection options
-	options := &clickhouse.Options{
-		Addr: []string{fmt.Sprintf("%s:%s", config.Host, config.Port)},
-		Auth: clickhouse.Auth{
-			Database: config.Database,
-			Username: config.User,
-			// Use password for authentication
-			Password: config.Password,
-		},
-	}
-
-	conn, err := clickhouse.Open(options)
+	conn, err := connections.Connect(c, c.GetString(sessionContextKey), config)
 	if err != nil {
 		log.Println("Connection failed: ", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Connection failed: " + err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	c.Set(connectionContextKey, conn)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Connected successfully", "database": conn.Database})
+}
 
-	if err := conn.Ping(c); err != nil {
-		log.Println("Ping failed: ", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ping failed: " + err.Error()})
+// GetClickHouseDatabases lists every database on the server.
+func GetClickHouseDatabases(c *gin.Context) {
+	conn, ok := requireConnection(c)
+	if !ok {
 		return
 	}
-
-	clickhouseConn = conn
-	c.JSON(http.StatusOK, gin.H{"message": "Connected successfully"})
+	rows, err := conn.Conn.Query(c, "SELECT name FROM system.databases ORDER BY name")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	databases := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		databases = append(databases, name)
+	}
+	c.JSON(http.StatusOK, gin.H{"databases": databases, "default": conn.Database})
 }
 
+// GetClickHouseTables lists the tables of the database given by the
+// database query parameter, or of the connection's database.
 func GetClickHouseTables(c *gin.Context) {
-	if clickhouseConn == nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Service not initialized"})
+	conn, ok := requireConnection(c)
+	if !ok {
 		return
 	}
-	rows, err := clickhouseConn.Query(c, "SHOW TABLES")
+	database := c.DefaultQuery("database", conn.Database)
+	rows, err := conn.Conn.Query(c, "SHOW TABLES FROM "+services.QuoteIdentifier(database))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -83,12 +88,12 @@ func GetClickHouseTables(c *gin.Context) {
 }
 
 func GetClickHouseColumns(c *gin.Context) {
-	if clickhouseConn == nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Service not initialized"})
+	conn, ok := requireConnection(c)
+	if !ok {
 		return
 	}
-	table := c.Param("table")
-	rows, err := clickhouseConn.Query(c, "DESCRIBE TABLE "+table)
+	database, table := services.SplitTableName(c.Param("table"), c.DefaultQuery("database", conn.Database))
+	rows, err := conn.Conn.Query(c, "DESCRIBE TABLE "+services.QualifiedTable(database, table))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch columns: " + err.Error()})
 		return
@@ -105,3 +110,68 @@ func GetClickHouseColumns(c *gin.Context) {
 	}
 	c.JSON(http.StatusOK, columns)
 }
+
+// createTableRequest describes the table POST /tables/clickhouse creates.
+type createTableRequest struct {
+	// Table may be "table" or "database.table".
+	Table string `json:"table" binding:"required"`
+	services.TableSpec
+	// UploadID names an upload whose inferred columns are used when Columns
+	// is empty. SampleRows and the format options apply to the inference.
+	UploadID   string `json:"uploadId"`
+	SampleRows int    `json:"sampleRows"`
+	formatOptions
+	// DryRun only returns the DDL, for review before the table is created.
+	DryRun bool `json:"dryRun"`
+}
+
+// CreateClickHouseTable creates a table from the given or inferred columns
+// and returns the DDL it ran.
+func CreateClickHouseTable(c *gin.Context) {
+	var req createTableRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	conn, ok := requireConnection(c)
+	if !ok {
+		return
+	}
+
+	if len(req.Columns) == 0 && req.UploadID != "" {
+		upload, path, ok := findUpload(c, req.UploadID)
+		if !ok {
+			return
+		}
+		format, ok := uploadFormat(c, upload, req.formatOptions)
+		if !ok {
+			return
+		}
+		sampleRows := req.SampleRows
+		if sampleRows <= 0 || sampleRows > services.MaxSampleRows {
+			sampleRows = services.DefaultSampleRows
+		}
+		columns, err := inferredColumns(path, format, sampleRows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		req.Columns = columns
+	}
+
+	database, table := services.SplitTableName(req.Table, conn.Database)
+	ddl, err := req.TableSpec.DDL(database, table)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.DryRun {
+		c.JSON(http.StatusOK, gin.H{"ddl": ddl, "columns": req.Columns})
+		return
+	}
+	if err := conn.Conn.Exec(c, ddl); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to create table: " + err.Error(), "ddl": ddl})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"message": "Table created", "table": database + "." + table, "ddl": ddl})
+}
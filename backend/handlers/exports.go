@@ -0,0 +1,229 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+
+	"github.com/AditiKulkarni9/clickhouse-flatfile-tool/chtypes"
+	"github.com/AditiKulkarni9/clickhouse-flatfile-tool/services"
+	"github.com/gin-gonic/gin"
+)
+
+// exportStore keeps saved exports; it is configured by SetExportStore
+var exportStore *services.ExportStore
+
+// SetExportStore sets the store saved exports are written to
+func SetExportStore(s *services.ExportStore) {
+	exportStore = s
+}
+
+// ListExports returns the caller's saved exports, newest first
+func ListExports(c *gin.Context) {
+	exports, err := exportStore.List(c.GetString(sessionContextKey))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"exports": exports})
+}
+
+// DeleteExport removes one of the caller's saved exports
+func DeleteExport(c *gin.Context) {
+	err := exportStore.Delete(c.GetString(sessionContextKey), c.Param("id"))
+	if errors.Is(err, services.ErrExportNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Export deleted"})
+}
+
+// DownloadExport sends a saved export as an attachment. Range requests are
+// supported, so interrupted downloads can be resumed.
+func DownloadExport(c *gin.Context) {
+	export, file, err := exportStore.Open(c.GetString(sessionContextKey), c.Param("id"))
+	if errors.Is(err, services.ErrExportNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer file.Close()
+
+	c.Header("Content-Disposition", attachment(export.Name))
+	http.ServeContent(c.Writer, c.Request, export.Name, export.CreatedAt, file)
+}
+
+// StreamExport writes the selected ClickHouse columns straight into the
+// response, using chunked transfer encoding. Query parameters: table,
+// columns (repeated), format, compositeFormat, filename, encoding,
+// compression, gzip, rowGroupSize and parquetCompression.
+func StreamExport(c *gin.Context) {
+	var query struct {
+		Table           string   `form:"table" binding:"required"`
+		Columns         []string `form:"columns" binding:"required"`
+		Format          string   `form:"format"`
+		CompositeFormat string   `form:"compositeFormat"`
+		Filename        string   `form:"filename"`
+		Encoding        string   `form:"encoding"`
+		Compression     string   `form:"compression"`
+		Gzip            bool     `form:"gzip"`
+
+		RowGroupSize       int    `form:"rowGroupSize" binding:"min=0"`
+		ParquetCompression string `form:"parquetCompression"`
+	}
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := chtypes.ParseCompositeFormat(query.CompositeFormat); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	encoding, err := services.NormalizeEncoding(query.Encoding)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	format, err := services.LookupExportFormat(query.Format)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := services.ParquetCodec(query.ParquetCompression); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	codec, err := exportCodec(query.Compression, query.Gzip)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req := ingestRequest{
+		Source:             "clickhouse",
+		Table:              query.Table,
+		Columns:            query.Columns,
+		Target:             "flatfile",
+		CompositeFormat:    query.CompositeFormat,
+		Format:             format.Name,
+		RowGroupSize:       query.RowGroupSize,
+		ParquetCompression: query.ParquetCompression,
+		formatOptions:      formatOptions{Encoding: encoding},
+	}
+
+	conn, release, err := connections.Acquire(c.GetString(sessionContextKey))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Not connected to ClickHouse"})
+		return
+	}
+	defer release()
+
+	_, table := services.SplitTableName(query.Table, conn.Database)
+	name := query.Filename
+	if name == "" {
+		name = table + format.Extension
+	}
+	contentType := format.ContentType
+	if format.Text {
+		contentType += "; charset=" + encoding
+	}
+	if codec != nil {
+		name += codec.Extension
+		contentType = codec.ContentType
+	}
+
+	// Headers are only sent with the first row, so errors found while
+	// checking the table can still be reported as JSON.
+	out := &headerWriter{c: c, name: name, contentType: contentType}
+	var w io.Writer = out
+	var zw io.WriteCloser
+	if codec != nil {
+		if zw, err = codec.NewWriter(out); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		w = zw
+	}
+	flush := func() error {
+		// Codecs that cannot flush send their data as their blocks fill.
+		if f, ok := zw.(interface{ Flush() error }); ok {
+			if err := f.Flush(); err != nil {
+				return err
+			}
+		}
+		c.Writer.Flush()
+		return nil
+	}
+
+	_, err = writeExport(c.Request.Context(), conn.Conn, conn.Database, req, w, noProgress{}, flush)
+	if err == nil && zw != nil {
+		err = zw.Close()
+	}
+	if err != nil {
+		if !c.Writer.Written() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		abortStream(c, err)
+	}
+}
+
+// headerWriter sets the download headers before the first write.
+type headerWriter struct {
+	c           *gin.Context
+	name        string
+	contentType string
+}
+
+func (w *headerWriter) Write(p []byte) (int, error) {
+	if !w.c.Writer.Written() {
+		w.c.Header("Content-Type", w.contentType)
+		w.c.Header("Content-Disposition", attachment(w.name))
+		w.c.Header("X-Content-Type-Options", "nosniff")
+		w.c.Status(http.StatusOK)
+	}
+	return w.c.Writer.Write(p)
+}
+
+// abortStream cuts the connection of a response that failed half way, so
+// the client sees a failed download rather than a truncated file.
+func abortStream(c *gin.Context, err error) {
+	log.Printf("Export stream failed: %v", err)
+	conn, _, hijackErr := c.Writer.Hijack()
+	if hijackErr != nil {
+		return
+	}
+	conn.Close()
+}
+
+func attachment(name string) string {
+	if disposition := mime.FormatMediaType("attachment", map[string]string{"filename": name}); disposition != "" {
+		return disposition
+	}
+	return "attachment"
+}
+
+// exportCodec returns the codec an export is compressed with: compression,
+// or gzip if only the gzip flag is set.
+func exportCodec(compression string, gzip bool) (*services.Codec, error) {
+	if compression == "" && gzip {
+		compression = "gzip"
+	}
+	return services.LookupCodec(compression)
+}
+
+// noProgress discards progress updates of exports that do not run as a job.
+type noProgress struct{}
+
+func (noProgress) AddRows(int64)        {}
+func (noProgress) AddBytes(int64)       {}
+func (noProgress) SetRowsWritten(int64) {}
+func (noProgress) SetTotalRows(int64)   {}
@@ -2,47 +2,186 @@
 package handlers
 
 import (
-    "net/http"
-    "github.com/gin-gonic/gin"
-    "github.com/AditiKulkarni9/clickhouse-flatfile-tool/services"
+	"errors"
+	"net/http"
+
+	"github.com/AditiKulkarni9/clickhouse-flatfile-tool/models"
+	"github.com/AditiKulkarni9/clickhouse-flatfile-tool/services"
+	"github.com/gin-gonic/gin"
 )
 
+// uploadStore keeps uploaded files; it is configured by SetUploadStore
+var uploadStore *services.UploadStore
+
+// SetUploadStore sets the store uploads are saved to
+func SetUploadStore(s *services.UploadStore) {
+	uploadStore = s
+}
+
+// UploadFlatFile saves an uploaded file under a generated ID and returns its
+// metadata, including the detected format
 func UploadFlatFile(c *gin.Context) {
-    file, err := c.FormFile("file")
-    if err != nil {
-        c.JSON(http.StatusBadRequest, gin.H{"error": "File upload failed"})
-        return
-    }
-
-    filePath := "./uploads/" + file.Filename
-    if err := c.SaveUploadedFile(file, filePath); err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save file"})
-        return
-    }
-
-    delimiter := c.PostForm("delimiter")
-    if delimiter == "" {
-        delimiter = ","
-    }
-
-    c.JSON(http.StatusOK, gin.H{"filePath": filePath, "delimiter": delimiter})
+	header, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "File upload failed"})
+		return
+	}
+	file, err := header.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "File upload failed"})
+		return
+	}
+	defer file.Close()
+
+	upload, err := uploadStore.Save(c.GetString(sessionContextKey), c.GetString(userContextKey), header.Filename, file)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save file"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, upload)
 }
 
+// ListUploads returns the caller's uploads, newest first
+func ListUploads(c *gin.Context) {
+	uploads, err := uploadStore.List(c.GetString(sessionContextKey))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"uploads": uploads})
+}
+
+// GetUpload returns the metadata of one upload
+func GetUpload(c *gin.Context) {
+	upload, _, ok := findUpload(c, c.Param("id"))
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, upload)
+}
+
+// DeleteUpload removes an upload and its file
+func DeleteUpload(c *gin.Context) {
+	err := uploadStore.Delete(c.GetString(sessionContextKey), c.Param("id"))
+	if errors.Is(err, services.ErrUploadNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Upload deleted"})
+}
+
+// findUpload looks up one of the caller's uploads and returns the path of its
+// file, writing a 404 response if there is no such upload.
+func findUpload(c *gin.Context, id string) (*services.Upload, string, bool) {
+	upload, path, err := uploadStore.Path(c.GetString(sessionContextKey), id)
+	if errors.Is(err, services.ErrUploadNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return nil, "", false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, "", false
+	}
+	return upload, path, true
+}
+
+// formatOptions override parts of the format detected for an upload.
+type formatOptions struct {
+	Delimiter string  `json:"delimiter" form:"delimiter"`
+	Quote     *string `json:"quote" form:"quote"`
+	HasHeader *bool   `json:"hasHeader" form:"hasHeader"`
+	Encoding  string  `json:"encoding" form:"encoding"`
+	// Compression overrides the detected compression of an upload; "none"
+	// reads it as it is. Exports are compressed with it.
+	Compression string `json:"compression" form:"compression"`
+}
+
+// uploadFormat applies opts to the detected format of an upload, writing a
+// 400 response if the result cannot be read.
+func uploadFormat(c *gin.Context, upload *services.Upload, opts formatOptions) (services.FileFormat, bool) {
+	format := upload.Format
+	if opts.Delimiter != "" {
+		format.Delimiter = opts.Delimiter
+	}
+	if opts.Quote != nil {
+		format.Quote = *opts.Quote
+	}
+	if opts.HasHeader != nil {
+		format.HasHeader = *opts.HasHeader
+	}
+	if opts.Encoding != "" {
+		format.Encoding = opts.Encoding
+	}
+	if opts.Compression != "" {
+		codec, err := services.LookupCodec(opts.Compression)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return format, false
+		}
+		format.Compression = ""
+		if codec != nil {
+			format.Compression = codec.Name
+		}
+	}
+	if err := format.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return format, false
+	}
+	return format, true
+}
+
+// inferredColumns returns the columns of a flat file with their inferred
+// types, for creating a table from it.
+func inferredColumns(path string, format services.FileFormat, sampleRows int) ([]models.Column, error) {
+	inferred, err := services.NewFlatFileService(path, format).GetColumns(sampleRows)
+	if err != nil {
+		return nil, err
+	}
+	columns := make([]models.Column, len(inferred))
+	for i, col := range inferred {
+		columns[i] = col.Column
+	}
+	return columns, nil
+}
+
+// GetFlatFileColumns lists the columns of an upload, named by its header or
+// c1, c2, ... if it has none, with the ClickHouse types inferred from the
+// first sampleRows rows. The format defaults to the one detected at upload
+// time.
 func GetFlatFileColumns(c *gin.Context) {
-    filePath := c.Query("filePath")
-    delimiter := c.Query("delimiter")
-    if filePath == "" || delimiter == "" {
-        c.JSON(http.StatusBadRequest, gin.H{"error": "Missing filePath or delimiter"})
-        return
-    }
-
-    svc := services.NewFlatFileService(filePath, delimiter)
-    columns, err := svc.GetColumns()
-    if err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-        return
-    }
-    c.JSON(http.StatusOK, columns)
+	var query struct {
+		UploadID   string `form:"uploadId" binding:"required"`
+		SampleRows int    `form:"sampleRows" binding:"omitempty,min=1,max=100000"`
+		formatOptions
+	}
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if query.SampleRows == 0 {
+		query.SampleRows = services.DefaultSampleRows
+	}
+	upload, path, ok := findUpload(c, query.UploadID)
+	if !ok {
+		return
+	}
+	format, ok := uploadFormat(c, upload, query.formatOptions)
+	if !ok {
+		return
+	}
+
+	svc := services.NewFlatFileService(path, format)
+	columns, err := svc.GetColumns(query.SampleRows)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, columns)
 }
 /*const handleTableSelect = async (table) => {
       setSelectedTable(table);
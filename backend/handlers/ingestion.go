@@ -1,309 +1,821 @@
 package handlers
 
 import (
-	"encoding/csv"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
+	"path"
+	"slices"
+	"sort"
 	"strings"
+	"time"
 
+	"github.com/AditiKulkarni9/clickhouse-flatfile-tool/chtypes"
+	"github.com/AditiKulkarni9/clickhouse-flatfile-tool/services"
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
 	"github.com/gin-gonic/gin"
 )
 
 // getColumnTypes fetches column types from system.columns
-func getColumnTypes(c *gin.Context, database, table string) (map[string]string, error) {
+func getColumnTypes(ctx context.Context, conn driver.Conn, database, table string) (map[string]*chtypes.Type, error) {
 	query := `
 		SELECT name, type
 		FROM system.columns
 		WHERE database = ? AND table = ?
 	`
-	rows, err := clickhouseConn.Query(c, query, database, table)
+	rows, err := conn.Query(ctx, query, database, table)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query column types: %v", err)
 	}
 	defer rows.Close()
 
-	columnTypes := make(map[string]string)
+	// DateTime columns without an explicit zone use the server timezone
+	var serverZone *time.Location
+	if version, err := conn.ServerVersion(); err == nil {
+		serverZone = version.Timezone
+	}
+
+	columnTypes := make(map[string]*chtypes.Type)
 	for rows.Next() {
 		var name, typeStr string
 		if err := rows.Scan(&name, &typeStr); err != nil {
 			return nil, fmt.Errorf("failed to scan column types: %v", err)
 		}
-		switch {
-		case strings.HasPrefix(typeStr, "UInt32"):
-			columnTypes[name] = "UInt32"
-		case strings.HasPrefix(typeStr, "UInt16"):
-			columnTypes[name] = "UInt16"
-		case strings.HasPrefix(typeStr, "UInt8"):
-			columnTypes[name] = "UInt8"
-		case strings.HasPrefix(typeStr, "Float32"):
-			columnTypes[name] = "Float32"
-		case strings.HasPrefix(typeStr, "DateTime"):
-			columnTypes[name] = "DateTime"
-		case strings.HasPrefix(typeStr, "Enum"):
-			columnTypes[name] = "String"
-		default:
-			columnTypes[name] = "String"
+		typ, err := chtypes.Parse(typeStr)
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %v", name, err)
 		}
+		typ.SetDefaultLocation(serverZone)
+		columnTypes[name] = typ
 	}
 	return columnTypes, nil
 }
 
-func IngestData(c *gin.Context) {
-	var req struct {
-		Source  string   `json:"source"`
-		Table   string   `json:"table"`
-		Columns []string `json:"columns"`
-		Target  string   `json:"target"`
-		Output  string   `json:"output"`
+// scanTargets allocates rows.Scan destinations for the selected columns.
+func scanTargets(columns []string, columnTypes map[string]*chtypes.Type) ([]interface{}, error) {
+	valuePtrs := make([]interface{}, len(columns))
+	for i, col := range columns {
+		ptr, err := columnTypes[col].ScanTarget()
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %v", col, err)
+		}
+		valuePtrs[i] = ptr
 	}
+	return valuePtrs, nil
+}
+
+// encodeRow formats scanned values as CSV fields.
+func encodeRow(columns []string, columnTypes map[string]*chtypes.Type, valuePtrs []interface{}, format chtypes.CompositeFormat) ([]string, error) {
+	row := make([]string, len(columns))
+	for i, col := range columns {
+		field, err := columnTypes[col].EncodeWith(valuePtrs[i], format)
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %v", col, err)
+		}
+		row[i] = field
+	}
+	return row, nil
+}
+
+// ingestRequest describes a transfer between ClickHouse and a flat file.
+type ingestRequest struct {
+	Source string `json:"source"`
+	// Table is the source table of an export, or the upload ID of an import.
+	Table   string   `json:"table"`
+	Columns []string `json:"columns"`
+	Target  string   `json:"target"`
+	// Uploads and Glob add uploads to an import, which loads them all into
+	// one table: Uploads lists upload IDs and Glob matches the original
+	// names of the caller's uploads, such as sales_2026-*.csv.
+	Uploads []string `json:"uploads"`
+	Glob    string   `json:"glob"`
+	// Fields maps columns of an import to the file fields they are loaded
+	// from, such as user.id for a nested key of a JSON Lines file. Other
+	// columns are loaded from the field of the same name.
+	Fields map[string]string `json:"fields"`
+	// Output is the target table of an import, or the download file name of
+	// an export.
+	Output string `json:"output"`
+	// BatchSize and BatchBytes bound each INSERT sent to ClickHouse.
+	BatchSize  int   `json:"batchSize"`
+	BatchBytes int64 `json:"batchBytes"`
+	// CompositeFormat is how Array, Map and Tuple values appear in the CSV:
+	// "clickhouse" (default) or "json".
+	CompositeFormat string `json:"compositeFormat"`
+	// Gzip compresses saved exports with gzip when no compression is set.
+	Gzip bool `json:"gzip"`
+	// Format is the file format of an export: "csv" (default), "ndjson" or
+	// "parquet". RowGroupSize and ParquetCompression ("snappy", "zstd" or
+	// "none") tune Parquet exports.
+	Format             string `json:"format"`
+	RowGroupSize       int    `json:"rowGroupSize" binding:"min=0"`
+	ParquetCompression string `json:"parquetCompression"`
+	// Delimiter, Quote, HasHeader and Encoding override the format detected
+	// for an upload. Exports are written in Encoding, UTF-8 by default.
+	formatOptions
+	// MaxErrors is how many rows an import may reject before it fails, and
+	// MaxErrorRatio the largest share of rejected rows it may finish with.
+	// Rejected rows are saved as a CSV export; without either limit the
+	// first one fails the import.
+	MaxErrors     int     `json:"maxErrors" binding:"min=0"`
+	MaxErrorRatio float64 `json:"maxErrorRatio" binding:"min=0,max=1"`
+	// Atomic loads an import into a staging table first and only moves the
+	// rows into the target once all of them have been inserted, so a failed
+	// import leaves the target unchanged, unless moving its partitions fails
+	// partway, which is reported as a partial publish.
+	Atomic bool `json:"atomic"`
+	// WriteMode is what an import does with the rows already in the target:
+	// "append" (default), "truncate", "replacePartitions" or "upsert".
+	// VersionColumn names the version column of an upsert; the engine's
+	// own is used when it is empty. A version or sign column missing from
+	// the import is filled in.
+	WriteMode     string `json:"writeMode"`
+	VersionColumn string `json:"versionColumn"`
+	// CreateTable creates the target table of an import before loading it.
+	// Without columns, the columns and types are inferred from the upload.
+	CreateTable *services.TableSpec `json:"createTable"`
+
+	owner     string         // session key of the caller, set by the handler
+	sources   []importSource // uploads being imported, set by the handler
+	createDDL string         // CREATE TABLE statement run before an import, set by the handler
+}
+
+// importSource is an upload being imported. label names it in errors and
+// rejected rows: its original name, followed by the upload ID when another
+// upload of the import has the same name.
+type importSource struct {
+	uploadID string
+	name     string
+	label    string
+	path     string
+	format   services.FileFormat
+}
+
+// getTotalRows returns the row count ClickHouse reports for a table. Engines
+// that do not track it yield an error.
+func getTotalRows(ctx context.Context, conn driver.Conn, database, table string) (int64, error) {
+	var total *uint64
+	row := conn.QueryRow(ctx, "SELECT total_rows FROM system.tables WHERE database = ? AND name = ?", database, table)
+	if err := row.Scan(&total); err != nil {
+		return 0, fmt.Errorf("failed to query total rows: %v", err)
+	}
+	if total == nil {
+		return 0, fmt.Errorf("total rows unknown for table %s", table)
+	}
+	return int64(*total), nil
+}
+
+// IngestData queues the transfer as a background job and returns its ID
+// straight away. Progress and the final result are available from /jobs/:id.
+func IngestData(c *gin.Context) {
+	var req ingestRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	if clickhouseConn == nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Service not initialized"})
+	var run func(ctx context.Context, conn driver.Conn, database string, req ingestRequest, job *services.Job) (gin.H, error)
+	switch {
+	case req.Source == "clickhouse" && req.Target == "flatfile":
+		run = exportToFlatFile
+	case req.Source == "flatfile" && req.Target == "clickhouse":
+		run = importFromFlatFile
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid source/target combination"})
 		return
 	}
-
-	database := "uk"
-	tableName := req.Table
-	outputTable := req.Output
-	if req.Table == "uk_price_paid" {
-		tableName = "uk.uk_price_paid"
+	if _, err := chtypes.ParseCompositeFormat(req.CompositeFormat); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := services.NormalizeEncoding(req.Encoding); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
-	if req.Output == "uk_price_paid_import" {
-		outputTable = "uk.uk_price_paid_import"
+	if _, err := services.ParseWriteMode(req.WriteMode); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Target == "flatfile" {
+		if _, err := services.LookupExportFormat(req.Format); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if _, err := services.ParquetCodec(req.ParquetCompression); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if _, err := exportCodec(req.Compression, req.Gzip); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 	}
 
-	if req.Source == "clickhouse" && req.Target == "flatfile" {
-		simpleTable := strings.TrimPrefix(tableName, "uk.")
-		columnTypes, err := getColumnTypes(c, database, simpleTable)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	for col := range req.Fields {
+		if !slices.Contains(req.Columns, col) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("fields maps column %s, which is not selected", col)})
 			return
 		}
+	}
 
-		for _, col := range req.Columns {
-			if _, exists := columnTypes[col]; !exists {
-				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Column %s not found in table %s", col, tableName)})
-				return
-			}
+	req.owner = c.GetString(sessionContextKey)
+	if req.Source == "flatfile" {
+		sources, ok := importSources(c, &req)
+		if !ok {
+			return
 		}
+		req.sources = sources
+	}
 
-		selectedColumns := make([]string, len(req.Columns))
-		for i, col := range req.Columns {
-			if columnTypes[col] == "DateTime" {
-				selectedColumns[i] = fmt.Sprintf("formatDateTime(%s, '%%Y-%%m-%%d %%H:%%i:%%s') AS %s", col, col)
-			} else {
-				selectedColumns[i] = col
-			}
+	// Hold the session's connection open until the job has finished
+	conn, release, err := connections.Acquire(req.owner)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Not connected to ClickHouse"})
+		return
+	}
+	if req.CreateTable != nil && req.Source == "flatfile" {
+		if err := prepareCreateTable(&req, conn.Database); err != nil {
+			release()
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	job, err := jobManager.Submit(req.owner, "ingest", func(ctx context.Context, job *services.Job) (map[string]interface{}, error) {
+		return run(ctx, conn.Conn, conn.Database, req, job)
+	})
+	if err != nil {
+		release()
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+	go func() {
+		<-job.Done()
+		release()
+	}()
+	c.JSON(http.StatusAccepted, gin.H{"message": "Ingestion queued", "jobId": job.ID, "state": services.JobQueued})
+}
+
+// importSources looks up the uploads an import reads: req.Table, req.Uploads
+// and those matching req.Glob, in that order and each once. Glob matches are
+// sorted by original name, then upload time, as several uploads may share a
+// name. It writes an error response if there are none or one cannot be read.
+func importSources(c *gin.Context, req *ingestRequest) ([]importSource, bool) {
+	ids := append([]string{}, req.Uploads...)
+	if req.Table != "" {
+		ids = append([]string{req.Table}, ids...)
+	}
+	if req.Glob != "" {
+		if _, err := path.Match(req.Glob, ""); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid glob %q: %v", req.Glob, err)})
+			return nil, false
 		}
-		query := fmt.Sprintf("SELECT %s FROM %s", strings.Join(selectedColumns, ","), tableName)
-		rows, err := clickhouseConn.Query(c, query)
+		uploads, err := uploadStore.List(req.owner)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
+			return nil, false
+		}
+		var matches []*services.Upload
+		for _, upload := range uploads {
+			if ok, _ := path.Match(req.Glob, upload.OriginalName); ok {
+				matches = append(matches, upload)
+			}
+		}
+		if len(matches) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("no uploads match %q", req.Glob)})
+			return nil, false
+		}
+		sort.Slice(matches, func(i, j int) bool {
+			a, b := matches[i], matches[j]
+			if a.OriginalName != b.OriginalName {
+				return a.OriginalName < b.OriginalName
+			}
+			if !a.CreatedAt.Equal(b.CreatedAt) {
+				return a.CreatedAt.Before(b.CreatedAt)
+			}
+			return a.ID < b.ID
+		})
+		for _, upload := range matches {
+			ids = append(ids, upload.ID)
 		}
-		defer rows.Close()
+	}
+	if len(ids) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "table, uploads or glob must name the uploads to import"})
+		return nil, false
+	}
 
-		file, err := os.Create(req.Output)
+	var sources []importSource
+	names := make(map[string]int, len(ids))
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		upload, file, ok := findUpload(c, id)
+		if !ok {
+			return nil, false
+		}
+		format, ok := uploadFormat(c, upload, req.formatOptions)
+		if !ok {
+			return nil, false
+		}
+		sources = append(sources, importSource{uploadID: upload.ID, name: upload.OriginalName, label: upload.OriginalName, path: file, format: format})
+		names[upload.OriginalName]++
+	}
+	for i, src := range sources {
+		if names[src.name] > 1 {
+			sources[i].label = fmt.Sprintf("%s (%s)", src.name, src.uploadID)
+		}
+	}
+	return sources, true
+}
+
+// prepareCreateTable generates the DDL for req.CreateTable, inferring the
+// columns from the first upload if none are given. Without selected columns the
+// import loads every column of the new table.
+func prepareCreateTable(req *ingestRequest, defaultDatabase string) error {
+	spec := *req.CreateTable
+	if len(spec.Columns) == 0 {
+		src := req.sources[0]
+		columns, err := inferredColumns(src.path, src.format, services.DefaultSampleRows)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
+			return err
+		}
+		spec.Columns = columns
+	}
+	database, table := services.SplitTableName(req.Output, defaultDatabase)
+	ddl, err := spec.DDL(database, table)
+	if err != nil {
+		return err
+	}
+	if len(req.Columns) == 0 {
+		for _, col := range spec.Columns {
+			req.Columns = append(req.Columns, col.Name)
 		}
-		defer file.Close()
+	}
+	req.CreateTable = &spec
+	req.createDDL = ddl
+	return nil
+}
 
-		writer := csv.NewWriter(file)
-		defer writer.Flush()
+// exportToFlatFile saves the selected ClickHouse columns as an export in
+// the exports directory, in req.Format. req.Table may be "table" or "database.table" and
+// req.Output names the file offered for download.
+func exportToFlatFile(ctx context.Context, conn driver.Conn, defaultDatabase string, req ingestRequest, job *services.Job) (gin.H, error) {
+	database, table := services.SplitTableName(req.Table, defaultDatabase)
+	format, err := services.LookupExportFormat(req.Format)
+	if err != nil {
+		return nil, err
+	}
+	name := req.Output
+	if name == "" {
+		name = table + format.Extension
+	}
+	codec, err := exportCodec(req.Compression, req.Gzip)
+	if err != nil {
+		return nil, err
+	}
+	pending, err := exportStore.Create(req.owner, name, database+"."+table, codec)
+	if err != nil {
+		return nil, err
+	}
+	pending.Export.JobID = job.ID
 
-		if err := writer.Write(req.Columns); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to write CSV header: " + err.Error()})
-			return
+	var w io.Writer = pending
+	var zw io.WriteCloser
+	if codec != nil {
+		if zw, err = codec.NewWriter(pending); err != nil {
+			pending.Abort()
+			return nil, fmt.Errorf("failed to compress export: %v", err)
 		}
+		w = zw
+	}
+	count, err := writeExport(ctx, conn, defaultDatabase, req, w, job, nil)
+	if err == nil && zw != nil {
+		err = zw.Close()
+	}
+	if err != nil {
+		pending.Abort()
+		return nil, err
+	}
+	export, err := pending.Commit(count)
+	if err != nil {
+		return nil, err
+	}
+	return gin.H{
+		"message":     "Ingestion complete",
+		"recordCount": count,
+		"exportId":    export.ID,
+		"downloadUrl": "/exports/" + export.ID + "/download",
+	}, nil
+}
 
-		count := 0
-		for rows.Next() {
-			valuePtrs := make([]interface{}, len(req.Columns))
-			for i, col := range req.Columns {
-				switch columnTypes[col] {
-				case "UInt32":
-					var val uint32
-					valuePtrs[i] = &val
-				case "UInt16":
-					var val uint16
-					valuePtrs[i] = &val
-				case "UInt8":
-					var val uint8
-					valuePtrs[i] = &val
-				case "Float32":
-					var val float32
-					valuePtrs[i] = &val
-				default:
-					var val string
-					valuePtrs[i] = &val
-				}
-			}
-			if err := rows.Scan(valuePtrs...); err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-				return
-			}
-			row := make([]string, len(req.Columns))
-			for i, col := range req.Columns {
-				switch columnTypes[col] {
-				case "UInt32":
-					row[i] = fmt.Sprintf("%d", *(valuePtrs[i].(*uint32)))
-				case "UInt16":
-					row[i] = fmt.Sprintf("%d", *(valuePtrs[i].(*uint16)))
-				case "UInt8":
-					row[i] = fmt.Sprintf("%d", *(valuePtrs[i].(*uint8)))
-				case "Float32":
-					row[i] = fmt.Sprintf("%.2f", *(valuePtrs[i].(*float32)))
-				default:
-					row[i] = *(valuePtrs[i].(*string))
-				}
+// exportProgress receives export progress; *services.Job implements it.
+type exportProgress interface {
+	AddRows(n int64)
+	AddBytes(n int64)
+	SetRowsWritten(n int64)
+	SetTotalRows(n int64)
+}
+
+// exportFlushRows is how many rows are written between calls to flush.
+const exportFlushRows = 1000
+
+// writeExport writes the selected columns of req.Table to w in req.Format
+// and returns the number of rows written. Text formats are written in
+// req.Encoding. Nothing is written to w before the table and columns have
+// been checked. If flush is set it is called every exportFlushRows rows,
+// after the row writer has been flushed.
+func writeExport(ctx context.Context, conn driver.Conn, defaultDatabase string, req ingestRequest, w io.Writer, progress exportProgress, flush func() error) (int64, error) {
+	database, table := services.SplitTableName(req.Table, defaultDatabase)
+	tableName := database + "." + table
+	compositeFormat, _ := chtypes.ParseCompositeFormat(req.CompositeFormat)
+	format, err := services.LookupExportFormat(req.Format)
+	if err != nil {
+		return 0, err
+	}
+
+	columnTypes, err := getColumnTypes(ctx, conn, database, table)
+	if err != nil {
+		return 0, err
+	}
+
+	columns := make([]services.ExportColumn, len(req.Columns))
+	for i, col := range req.Columns {
+		typ, exists := columnTypes[col]
+		if !exists {
+			return 0, fmt.Errorf("column %s not found in table %s", col, tableName)
+		}
+		columns[i] = services.ExportColumn{Name: col, Type: typ}
+	}
+
+	if total, err := getTotalRows(ctx, conn, database, table); err == nil {
+		progress.SetTotalRows(total)
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM %s", services.QuoteIdentifiers(req.Columns), services.QualifiedTable(database, table))
+	rows, err := conn.Query(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	writer, err := format.NewWriter(w, columns, services.ExportOptions{
+		Encoding:        req.Encoding,
+		CompositeFormat: compositeFormat,
+		RowGroupSize:    req.RowGroupSize,
+		Compression:     req.ParquetCompression,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var count int64
+	for rows.Next() {
+		valuePtrs, err := scanTargets(req.Columns, columnTypes)
+		if err != nil {
+			return count, err
+		}
+		if err := rows.Scan(valuePtrs...); err != nil {
+			return count, err
+		}
+		size, err := writer.WriteRow(valuePtrs)
+		if err != nil {
+			return count, err
+		}
+		count++
+		progress.AddRows(1)
+		progress.AddBytes(size)
+		progress.SetRowsWritten(count)
+
+		if flush != nil && count%exportFlushRows == 0 {
+			if err := writer.Flush(); err != nil {
+				return count, err
 			}
-			if err := writer.Write(row); err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to write CSV row: " + err.Error()})
-				return
+			if err := flush(); err != nil {
+				return count, err
 			}
-			count++
 		}
+	}
 
-		c.JSON(http.StatusOK, gin.H{"message": "Ingestion complete", "recordCount": count})
-	} else if req.Source == "flatfile" && req.Target == "clickhouse" {
-		file, err := os.Open(req.Table)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open CSV: " + err.Error()})
-			return
+	if err := rows.Err(); err != nil {
+		return count, err
+	}
+	if err := writer.Close(); err != nil {
+		return count, err
+	}
+	return count, nil
+}
+
+// importFromFlatFile loads the selected columns of one or more uploads into
+// a ClickHouse table, sharing batches across files. req.Output may be
+// "table" or "database.table".
+func importFromFlatFile(ctx context.Context, conn driver.Conn, defaultDatabase string, req ingestRequest, job *services.Job) (gin.H, error) {
+	database, table := services.SplitTableName(req.Output, defaultDatabase)
+	outputTable := database + "." + table
+	format, _ := chtypes.ParseCompositeFormat(req.CompositeFormat)
+
+	if req.createDDL != "" {
+		if err := conn.Exec(ctx, req.createDDL); err != nil {
+			return nil, fmt.Errorf("failed to create table: %v", err)
 		}
-		defer file.Close()
+	}
 
-		reader := csv.NewReader(file)
-		headers, err := reader.Read()
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read CSV headers: " + err.Error()})
-			return
+	fields := make([]string, len(req.Columns))
+	for i, col := range req.Columns {
+		fields[i] = col
+		if field, ok := req.Fields[col]; ok {
+			fields[i] = field
 		}
+	}
+	headers, err := checkHeaders(req.sources, fields, job)
+	if err != nil {
+		return nil, err
+	}
 
-		// Create a map of CSV headers to their indices
-		headerMap := make(map[string]int)
-		for i, header := range headers {
-			headerMap[header] = i
+	// Get target table column types
+	columnTypes, err := getColumnTypes(ctx, conn, database, table)
+	if err != nil {
+		return nil, err
+	}
+	for _, col := range req.Columns {
+		if _, exists := columnTypes[col]; !exists {
+			return nil, fmt.Errorf("column %s not found in table %s", col, outputTable)
 		}
+	}
 
-		// Get target table column types
-		simpleOutputTable := strings.TrimPrefix(outputTable, "uk.")
-		columnTypes, err := getColumnTypes(c, database, simpleOutputTable)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
+	mode, _ := services.ParseWriteMode(req.WriteMode)
+	plan, err := services.PlanWrite(ctx, conn, database, table, mode, req.VersionColumn)
+	if err != nil {
+		return nil, err
+	}
+	defaultColumns, defaultValues, err := plan.Defaults(req.Columns, columnTypes, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	staged, err := plan.Staged(req.Atomic)
+	if err != nil {
+		return nil, err
+	}
+
+	insertTable := services.QualifiedTable(database, table)
+	var staging *services.StagingTable
+	if staged {
+		if staging, err = services.CreateStagingTable(ctx, conn, database, table, job.ID); err != nil {
+			return nil, err
+		}
+		defer staging.Drop()
+		insertTable = staging.Table()
+	} else if mode == services.WriteTruncate {
+		if err := services.TruncateTable(ctx, conn, database, table); err != nil {
+			return nil, err
 		}
+	}
+
+	insertColumns := append(append([]string{}, req.Columns...), defaultColumns...)
+	inserter := services.NewBatchInserter(conn, insertTable, insertColumns, req.BatchSize, req.BatchBytes)
+	defer inserter.Close()
 
-		// Validate and map columns
-		colIndices := make([]int, len(req.Columns))
-		for i, col := range req.Columns {
-			if idx, exists := headerMap[col]; exists {
-				colIndices[i] = idx
-			} else {
-				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Column %s not found in CSV. Available headers: %v", col, headers)})
-				return
+	rejected := newRejects(req, table, headers[0], job)
+	// fail keeps the rows rejected so far for inspection.
+	fail := func(err error) (gin.H, error) {
+		if export, saveErr := rejected.save(); saveErr == nil && export != nil {
+			err = fmt.Errorf("%v; rejected rows saved as export %s", err, export.ID)
+		}
+		return nil, err
+	}
+
+	// loadFile appends the rows of one file to the batches and returns how
+	// many were accepted. Errors of imports of several files name the file.
+	loadFile := func(src importSource, headers []string) (int, error) {
+		fileErr := func(err error) error {
+			if len(req.sources) > 1 {
+				return fmt.Errorf("%s: %v", src.label, err)
 			}
+			return err
+		}
+		reader, err := services.OpenRecords(src.path, src.format, fields)
+		if err != nil {
+			return 0, fileErr(err)
+		}
+		defer reader.Close()
+		if _, err := reader.Header(); err != nil {
+			return 0, fileErr(fmt.Errorf("failed to read headers: %v", err))
 		}
 
-		// Prepare the INSERT query
-		placeholders := strings.Repeat("?, ", len(req.Columns))
-		placeholders = placeholders[:len(placeholders)-2]
-		query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", outputTable, strings.Join(req.Columns, ","), placeholders)
+		// Map the selected fields to their place in the file
+		headerMap := make(map[string]int)
+		for i, header := range headers {
+			headerMap[header] = i
+		}
+		colIndices := make([]int, len(fields))
+		for i, field := range fields {
+			colIndices[i] = headerMap[field]
+		}
 
 		count := 0
-		batchSize := 1000
-		batch := make([][]interface{}, 0, batchSize)
-
 		for {
+			if err := ctx.Err(); err != nil {
+				return count, err
+			}
 			record, err := reader.Read()
-			if err != nil {
-				if err.Error() == "EOF" {
-					break
+			if err == io.EOF {
+				return count, nil
+			}
+			text := services.RecordText(record)
+			size := recordSize(text)
+			if errors.Is(err, services.ErrFieldCount) || errors.Is(err, services.ErrMalformedRecord) {
+				job.AddRows(1)
+				job.AddBytes(size)
+				reason := err.Error()
+				if errors.Is(err, services.ErrFieldCount) {
+					reason = fmt.Sprintf("wrong number of fields: expected %d, got %d", len(headers), len(record))
 				}
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read CSV row: " + err.Error()})
-				return
+				if err := rejected.add(reader.Line(), "", reason, text); err != nil {
+					return count, err
+				}
+				continue
+			}
+			if err != nil {
+				return count, fileErr(fmt.Errorf("failed to read row: %v", err))
 			}
 
-			values := make([]interface{}, len(req.Columns))
+			values := make([]interface{}, len(insertColumns))
+			copy(values[len(req.Columns):], defaultValues)
+			badColumn, reason := "", ""
 			for i, idx := range colIndices {
 				col := req.Columns[i]
 				value := record[idx]
 
-				switch columnTypes[col] {
-				case "UInt32":
-					var val uint32
-					if _, err := fmt.Sscanf(value, "%d", &val); err != nil {
-						c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid UInt32 value for column %s: %s", col, value)})
-						return
-					}
-					values[i] = val
-				case "UInt16":
-					var val uint16
-					if _, err := fmt.Sscanf(value, "%d", &val); err != nil {
-						c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid UInt16 value for column %s: %s", col, value)})
-						return
-					}
-					values[i] = val
-				case "UInt8":
-					var val uint8
-					if _, err := fmt.Sscanf(value, "%d", &val); err != nil {
-						c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid UInt8 value for column %s: %s", col, value)})
-						return
-					}
-					values[i] = val
-				case "Float32":
-					var val float32
-					if _, err := fmt.Sscanf(value, "%f", &val); err != nil {
-						c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid Float32 value for column %s: %s", col, value)})
-						return
-					}
-					values[i] = val
-				case "DateTime":
-					// For String type, just pass the value directly
-					values[i] = value
-				default:
-					values[i] = value
+				typ := columnTypes[col]
+				var val interface{}
+				var err error
+				if value == nil {
+					// Missing and null values get the default of the column
+					// type, as ClickHouse gives them.
+					val, err = typ.Default()
+				} else {
+					val, err = typ.DecodeValue(value, format)
 				}
+				if err != nil {
+					badColumn, reason = col, fmt.Sprintf("invalid %s value for column %s: %s", typ, col, text[idx])
+					break
+				}
+				values[i] = val
+			}
+			job.AddRows(1)
+			job.AddBytes(size)
+			if badColumn != "" {
+				if err := rejected.add(reader.Line(), badColumn, reason, text); err != nil {
+					return count, err
+				}
+				continue
 			}
 
-			batch = append(batch, values)
+			if err := inserter.Append(ctx, values, size); err != nil {
+				return count, fileErr(fmt.Errorf("failed to insert batch: %v", err))
+			}
 			count++
+			job.SetRowsWritten(inserter.Rows)
+			job.SetBatch(int64(inserter.Batches) + 1)
+		}
+	}
 
-			// Execute batch insert when batch size is reached
-			if len(batch) >= batchSize {
-				if err := executeBatch(c, query, batch); err != nil {
-					c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to insert batch: " + err.Error()})
-					return
-				}
-				batch = batch[:0]
-			}
+	start := time.Now()
+	count := 0
+	files := make([]gin.H, len(req.sources))
+	for i, src := range req.sources {
+		rejected.setFile(src.label, headers[i])
+		accepted, err := loadFile(src, headers[i])
+		count += accepted
+		if err != nil {
+			return fail(err)
+		}
+		files[i] = gin.H{
+			"uploadId": src.uploadID,
+			"name":     src.label,
+			"accepted": accepted,
+			"rejected": rejected.fileCount,
+			"errors":   rejected.fileErrors,
 		}
+	}
 
-		// Execute remaining records
-		if len(batch) > 0 {
-			if err := executeBatch(c, query, batch); err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to insert final batch: " + err.Error()})
-				return
-			}
+	// Send remaining records
+	if err := inserter.Flush(); err != nil {
+		return fail(fmt.Errorf("failed to insert final batch: %v", err))
+	}
+	job.SetRowsWritten(inserter.Rows)
+	if err := rejected.check(int64(count)); err != nil {
+		return fail(err)
+	}
+	if staging != nil {
+		if err := staging.Publish(ctx, plan, uint64(inserter.Rows)); err != nil {
+			return fail(err)
 		}
+	}
+	rejectsExport, err := rejected.save()
+	if err != nil {
+		return nil, err
+	}
 
-		c.JSON(http.StatusOK, gin.H{"message": "Ingestion complete", "recordCount": count})
-	} else {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid source/target combination"})
+	elapsed := time.Since(start)
+	result := gin.H{
+		"message":        "Ingestion complete",
+		"writeMode":      mode,
+		"recordCount":    count,
+		"accepted":       count,
+		"rejected":       rejected.count,
+		"files":          files,
+		"bytes":          inserter.Bytes,
+		"batches":        inserter.Batches,
+		"durationMs":     elapsed.Milliseconds(),
+		"rowsPerSecond":  perSecond(int64(count), elapsed),
+		"bytesPerSecond": perSecond(inserter.Bytes, elapsed),
+	}
+	if rejectsExport != nil {
+		result["rejectsExportId"] = rejectsExport.ID
+		result["rejectsUrl"] = "/exports/" + rejectsExport.ID + "/download"
+	}
+	if req.createDDL != "" {
+		result["ddl"] = req.createDDL
 	}
+	return result, nil
 }
 
-func executeBatch(c *gin.Context, query string, batch [][]interface{}) error {
-	for _, values := range batch {
-		if err := clickhouseConn.Exec(c, query, values...); err != nil {
-			return err
+// checkHeaders reads the header of every file of an import before any row
+// is loaded, and fails unless each has all the selected fields. It also
+// gives the job the total to estimate completion against: the rows of files
+// that record them, such as Parquet, or else the size of uncompressed ones.
+// The size of a compressed file says little about the rows in it, so
+// imports including one go without an estimate.
+func checkHeaders(sources []importSource, fields []string, job *services.Job) ([][]string, error) {
+	headers := make([][]string, len(sources))
+	var problems []string
+	var totalRows, totalBytes int64
+	estimate := true
+	for i, src := range sources {
+		reader, err := services.OpenRecords(src.path, src.format, fields)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", src.label, err)
+		}
+		header, err := reader.Header()
+		rows := reader.TotalRows()
+		reader.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: failed to read headers: %v", src.label, err)
+		}
+		headers[i] = header
+
+		var missing []string
+		for _, field := range fields {
+			if !slices.Contains(header, field) {
+				missing = append(missing, field)
+			}
+		}
+		if len(missing) > 0 {
+			problems = append(problems, fmt.Sprintf("%s lacks %s (available headers: %v)", src.label, strings.Join(missing, ", "), header))
+		}
+
+		switch info, err := os.Stat(src.path); {
+		case rows > 0:
+			totalRows += rows
+		case err == nil && src.format.Compression == "":
+			totalBytes += info.Size()
+		default:
+			estimate = false
 		}
 	}
-	return nil
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("columns not found in file: %s", strings.Join(problems, "; "))
+	}
+
+	switch {
+	case !estimate:
+	case totalRows == 0:
+		job.SetTotalBytes(totalBytes)
+	case totalBytes == 0:
+		job.SetTotalRows(totalRows)
+	}
+	return headers, nil
+}
+
+// recordSize approximates the number of CSV bytes a record was read from:
+// the field contents plus one separator or newline per field. Records of
+// other file types are measured by their text.
+func recordSize(record []string) int64 {
+	size := int64(len(record))
+	for _, field := range record {
+		size += int64(len(field))
+	}
+	return size
+}
+
+func perSecond(n int64, elapsed time.Duration) float64 {
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(n) / elapsed.Seconds()
 }
@@ -0,0 +1,255 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/AditiKulkarni9/clickhouse-flatfile-tool/services"
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+	"github.com/gin-gonic/gin"
+)
+
+// fakeConn serves one table to exports and records the rows imports insert.
+type fakeConn struct {
+	driver.Conn
+	columns  []string
+	types    []string
+	rows     [][]interface{}
+	inserted [][]interface{}
+}
+
+func (c *fakeConn) ServerVersion() (*driver.ServerVersion, error) {
+	return nil, errors.New("no server")
+}
+
+func (c *fakeConn) Query(ctx context.Context, query string, args ...any) (driver.Rows, error) {
+	if strings.Contains(query, "system.columns") {
+		rows := make([][]interface{}, len(c.columns))
+		for i, col := range c.columns {
+			rows[i] = []interface{}{col, c.types[i]}
+		}
+		return &fakeRows{rows: rows}, nil
+	}
+	return &fakeRows{rows: c.rows}, nil
+}
+
+func (c *fakeConn) QueryRow(ctx context.Context, query string, args ...any) driver.Row {
+	if strings.Contains(query, "engine") {
+		return &fakeRows{rows: [][]interface{}{{"MergeTree", "MergeTree ORDER BY id", "", "id"}}}
+	}
+	return &fakeRows{}
+}
+
+func (c *fakeConn) PrepareBatch(ctx context.Context, query string, opts ...driver.PrepareBatchOption) (driver.Batch, error) {
+	return &fakeBatch{conn: c}, nil
+}
+
+// fakeRows returns rows, and is also the result of QueryRow.
+type fakeRows struct {
+	driver.Rows
+	rows [][]interface{}
+	next int
+}
+
+func (r *fakeRows) Next() bool {
+	r.next++
+	return r.next <= len(r.rows)
+}
+
+func (r *fakeRows) Scan(dest ...any) error {
+	if r.next == 0 {
+		r.next = 1
+	}
+	if r.next > len(r.rows) {
+		return errors.New("no rows")
+	}
+	for i, v := range r.rows[r.next-1] {
+		reflect.ValueOf(dest[i]).Elem().Set(reflect.ValueOf(v))
+	}
+	return nil
+}
+
+func (r *fakeRows) ScanStruct(dest any) error { return errors.New("not supported") }
+func (r *fakeRows) Err() error                { return nil }
+func (r *fakeRows) Close() error              { return nil }
+
+type fakeBatch struct {
+	driver.Batch
+	conn *fakeConn
+	rows [][]interface{}
+}
+
+func (b *fakeBatch) Append(v ...any) error {
+	b.rows = append(b.rows, v)
+	return nil
+}
+
+func (b *fakeBatch) Send() error {
+	b.conn.inserted = append(b.conn.inserted, b.rows...)
+	return nil
+}
+
+func (b *fakeBatch) Abort() error { return nil }
+
+func ptr[T any](v T) *T { return &v }
+
+// runJob runs fn as a job and returns its result.
+func runJob(t *testing.T, fn services.JobFunc) map[string]interface{} {
+	t.Helper()
+	job, err := jobManager.Submit("owner", "test", fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-job.Done()
+	status := job.Status()
+	if status.State != services.JobSucceeded {
+		t.Fatalf("job %s: %s", status.State, status.Error)
+	}
+	return status.Result
+}
+
+func TestCompositeExportImportRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	uploads, err := services.NewUploadStore(dir+"/uploads", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exports, err := services.NewExportStore(dir+"/exports", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetUploadStore(uploads)
+	SetExportStore(exports)
+
+	columns := []string{"id", "tags", "attrs", "point", "items", "matrix"}
+	types := []string{
+		"UInt32",
+		"Array(Nullable(String))",
+		"Map(String, Array(UInt8))",
+		"Tuple(name String, score Nullable(Float64))",
+		"Nested(sku String, qty UInt16)",
+		"Array(Array(Int64))",
+	}
+	rows := [][]interface{}{
+		{
+			uint32(1),
+			[]*string{ptr("a,b"), nil, ptr(`it's "quoted"`), ptr(`\N`), ptr("line\nbreak")},
+			map[string][]uint8{"k'1": {1, 2}, "empty": {}},
+			[]interface{}{"o'neil, jr", ptr(2.5)},
+			[][]interface{}{{"sku-1", uint16(3)}, {"[x]", uint16(0)}},
+			[][]int64{{1, -2}, {}, {3}},
+		},
+		{
+			uint32(2),
+			[]*string{},
+			map[string][]uint8{},
+			[]interface{}{"", (*float64)(nil)},
+			[][]interface{}{},
+			[][]int64{},
+		},
+	}
+
+	for _, format := range []string{"clickhouse", "json"} {
+		conn := &fakeConn{columns: columns, types: types, rows: rows}
+		result := runJob(t, func(ctx context.Context, job *services.Job) (map[string]interface{}, error) {
+			return exportToFlatFile(ctx, conn, "default", ingestRequest{
+				Source:          "clickhouse",
+				Table:           "src",
+				Columns:         columns,
+				Target:          "flatfile",
+				CompositeFormat: format,
+				owner:           "owner",
+			}, job)
+		})
+
+		_, file, err := exports.Open("owner", result["exportId"].(string))
+		if err != nil {
+			t.Fatal(err)
+		}
+		upload, err := uploads.Save("owner", "user", "src.csv", file)
+		file.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req := ingestRequest{
+			Source:          "flatfile",
+			Table:           upload.ID,
+			Columns:         columns,
+			Target:          "clickhouse",
+			Output:          "dst",
+			CompositeFormat: format,
+			owner:           "owner",
+		}
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Set(sessionContextKey, "owner")
+		sources, ok := importSources(c, &req)
+		if !ok {
+			t.Fatalf("%s: %s", format, w.Body)
+		}
+		req.sources = sources
+		runJob(t, func(ctx context.Context, job *services.Job) (map[string]interface{}, error) {
+			return importFromFlatFile(ctx, conn, "default", req, job)
+		})
+
+		if !reflect.DeepEqual(conn.inserted, rows) {
+			t.Errorf("%s: imported %#v, want %#v", format, conn.inserted, rows)
+		}
+	}
+}
+
+func TestImportSourcesGlob(t *testing.T) {
+	uploads, err := services.NewUploadStore(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetUploadStore(uploads)
+
+	var saved []*services.Upload
+	for _, name := range []string{"b.csv", "a.csv", "a.csv", "c.tsv"} {
+		upload, err := uploads.Save("owner", "user", name, strings.NewReader("id\n1\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		saved = append(saved, upload)
+	}
+
+	sources := func(glob string) ([]importSource, *httptest.ResponseRecorder) {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Set(sessionContextKey, "owner")
+		srcs, _ := importSources(c, &ingestRequest{Glob: glob, owner: "owner"})
+		return srcs, w
+	}
+
+	srcs, w := sources("*.csv")
+	var got []string
+	for _, src := range srcs {
+		got = append(got, src.uploadID+" "+src.label)
+	}
+	if n := len(srcs); n == 0 || srcs[n-1].label != "b.csv" {
+		t.Errorf("*.csv: got %v, want b.csv last (%s)", got, w.Body)
+	}
+	// The two a.csv uploads may share a CreatedAt, so their order is not fixed.
+	sort.Strings(got)
+	want := []string{
+		saved[0].ID + " b.csv",
+		saved[1].ID + " a.csv (" + saved[1].ID + ")",
+		saved[2].ID + " a.csv (" + saved[2].ID + ")",
+	}
+	sort.Strings(want)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("*.csv: got %v, want %v (%s)", got, want, w.Body)
+	}
+
+	if srcs, w := sources("*.json"); srcs != nil || w.Code != http.StatusBadRequest {
+		t.Errorf("*.json: got %v, status %d, want 400", srcs, w.Code)
+	}
+}
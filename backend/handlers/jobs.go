@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/AditiKulkarni9/clickhouse-flatfile-tool/services"
+	"github.com/gin-gonic/gin"
+)
+
+var jobManager = services.NewJobManager(services.DefaultJobWorkers, services.DefaultJobQueueSize, services.DefaultJobRetention, services.DefaultMaxFinishedJobs)
+
+// ListJobs returns the status of every job of the caller.
+func ListJobs(c *gin.Context) {
+	c.JSON(http.StatusOK, jobManager.List(c.GetString(sessionContextKey)))
+}
+
+// GetJob returns the status of a single job.
+func GetJob(c *gin.Context) {
+	job, err := jobManager.Get(c.GetString(sessionContextKey), c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, job.Status())
+}
+
+// CancelJob cancels a queued or running job.
+func CancelJob(c *gin.Context) {
+	err := jobManager.Cancel(c.GetString(sessionContextKey), c.Param("id"))
+	switch {
+	case errors.Is(err, services.ErrJobNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, services.ErrJobFinished):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Job canceled"})
+}
+
+// StreamJobEvents pushes "progress" events for a job over Server-Sent Events
+// until it finishes, then sends a final "done" event with the job status.
+// The optional interval query parameter (e.g. "500ms") sets the update rate.
+func StreamJobEvents(c *gin.Context) {
+	job, err := jobManager.Get(c.GetString(sessionContextKey), c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	interval := time.Second
+	if raw := c.Query("interval"); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d < 100*time.Millisecond {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "interval must be a duration of at least 100ms"})
+			return
+		}
+		interval = d
+	}
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	c.SSEvent("progress", job.Progress())
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-ticker.C:
+			c.SSEvent("progress", job.Progress())
+			return true
+		case <-job.Done():
+			c.SSEvent("progress", job.Progress())
+			c.SSEvent("done", job.Status())
+			return false
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
@@ -1,20 +1,20 @@
 package handlers
 
 import (
-	"encoding/csv"
 	"fmt"
 	"net/http"
-	"os"
-	"path/filepath"
-	"strings"
+	"github.com/AditiKulkarni9/clickhouse-flatfile-tool/chtypes"
+	"github.com/AditiKulkarni9/clickhouse-flatfile-tool/services"
 	"github.com/gin-gonic/gin"
 	"io"
 )
 
 type PreviewRequest struct {
 	Source  string   `json:"source"`
-	Table   string   `json:"table"`
+	Table   string   `json:"table"` // upload ID for flat files
 	Columns []string `json:"columns"`
+	// Delimiter, Quote, HasHeader and Encoding override the format detected for an upload
+	formatOptions
 }
 
 type PreviewResponse struct {
@@ -33,17 +33,13 @@ func PreviewData(c *gin.Context) {
 	var headers []string
 
 	if req.Source == "clickhouse" {
-		if clickhouseConn == nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Not connected to ClickHouse"})
+		conn, ok := requireConnection(c)
+		if !ok {
 			return
 		}
-		database := "uk"
-		tableName := req.Table
-		if req.Table == "uk_price_paid" {
-			tableName = "uk.uk_price_paid"
-		}
-		simpleTable := strings.TrimPrefix(tableName, "uk.")
-		columnTypes, err := getColumnTypes(c, database, simpleTable)
+		database, table := services.SplitTableName(req.Table, conn.Database)
+		tableName := database + "." + table
+		columnTypes, err := getColumnTypes(c, conn.Conn, database, table)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
@@ -56,16 +52,8 @@ func PreviewData(c *gin.Context) {
 			}
 		}
 
-		selectedColumns := make([]string, len(req.Columns))
-		for i, col := range req.Columns {
-			if columnTypes[col] == "DateTime" {
-				selectedColumns[i] = fmt.Sprintf("formatDateTime(%s, '%%Y-%%m-%%d %%H:%%i:%%s') AS %s", col, col)
-			} else {
-				selectedColumns[i] = col
-			}
-		}
-		query := fmt.Sprintf("SELECT %s FROM %s LIMIT 5", strings.Join(selectedColumns, ","), tableName)
-		dbRows, err := clickhouseConn.Query(c, query)
+		query := fmt.Sprintf("SELECT %s FROM %s LIMIT 5", services.QuoteIdentifiers(req.Columns), services.QualifiedTable(database, table))
+		dbRows, err := conn.Conn.Query(c, query)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
@@ -74,72 +62,52 @@ func PreviewData(c *gin.Context) {
 
 		headers = req.Columns
 		for dbRows.Next() {
-			valuePtrs := make([]interface{}, len(req.Columns))
-			for i, col := range req.Columns {
-				switch columnTypes[col] {
-				case "UInt32":
-					var val uint32
-					valuePtrs[i] = &val
-				case "UInt16":
-					var val uint16
-					valuePtrs[i] = &val
-				case "UInt8":
-					var val uint8
-					valuePtrs[i] = &val
-				case "Float32":
-					var val float32
-					valuePtrs[i] = &val
-				default:
-					var val string
-					valuePtrs[i] = &val
-				}
+			valuePtrs, err := scanTargets(req.Columns, columnTypes)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
 			}
 			if err := dbRows.Scan(valuePtrs...); err != nil {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 				return
 			}
-			row := make([]string, len(req.Columns))
-			for i, col := range req.Columns {
-				switch columnTypes[col] {
-				case "UInt32":
-					row[i] = fmt.Sprintf("%d", *(valuePtrs[i].(*uint32)))
-				case "UInt16":
-					row[i] = fmt.Sprintf("%d", *(valuePtrs[i].(*uint16)))
-				case "UInt8":
-					row[i] = fmt.Sprintf("%d", *(valuePtrs[i].(*uint8)))
-				case "Float32":
-					row[i] = fmt.Sprintf("%.2f", *(valuePtrs[i].(*float32)))
-				default:
-					row[i] = *(valuePtrs[i].(*string))
-				}
+			row, err := encodeRow(req.Columns, columnTypes, valuePtrs, chtypes.FormatClickHouse)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
 			}
 			rows = append(rows, row)
 		}
 	} else if req.Source == "flatfile" {
-		filePath := filepath.Join("Uploads", filepath.Base(req.Table))
-		file, err := os.Open(filePath)
+		upload, filePath, ok := findUpload(c, req.Table)
+		if !ok {
+			return
+		}
+		format, ok := uploadFormat(c, upload, req.formatOptions)
+		if !ok {
+			return
+		}
+		reader, err := services.OpenRecords(filePath, format, req.Columns)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open CSV: " + err.Error()})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open file: " + err.Error()})
 			return
 		}
-		defer file.Close()
+		defer reader.Close()
 
-		reader := csv.NewReader(file)
-		reader.Comma = ','
-		csvHeaders, err := reader.Read()
+		fileHeaders, err := reader.Header()
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read CSV headers: " + err.Error()})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read headers: " + err.Error()})
 			return
 		}
 
 		headerMap := make(map[string]int)
-		for i, h := range csvHeaders {
+		for i, h := range fileHeaders {
 			headerMap[h] = i
 		}
 		headers = req.Columns
 		for _, col := range req.Columns {
 			if _, ok := headerMap[col]; !ok {
-				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Column %s not found in CSV", col)})
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Column %s not found in file", col)})
 				return
 			}
 		}
@@ -151,12 +119,13 @@ func PreviewData(c *gin.Context) {
 				break
 			}
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read CSV: " + err.Error()})
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read file: " + err.Error()})
 				return
 			}
+			text := services.RecordText(record)
 			row := make([]string, len(req.Columns))
 			for i, col := range req.Columns {
-				row[i] = record[headerMap[col]]
+				row[i] = text[headerMap[col]]
 			}
 			rows = append(rows, row)
 			count++
@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"fmt"
+	"slices"
+	"strconv"
+
+	"github.com/AditiKulkarni9/clickhouse-flatfile-tool/services"
+)
+
+const (
+	// maxJobErrors is how many rejected rows are also listed in the job errors.
+	maxJobErrors = 100
+	// maxFileErrors is how many rejected rows are listed per file in the
+	// result of an import of several files.
+	maxFileErrors = 10
+)
+
+// rejects collects the rows an import could not load and decides when there
+// are too many. The rows are saved, with line number, column and reason, as
+// a CSV export of the caller, created on the first rejected row. Imports of
+// several files also save the file name, and their rows are rearranged into
+// the columns of the first file.
+type rejects struct {
+	owner     string
+	name      string
+	table     string
+	header    []string
+	files     bool
+	maxErrors int
+	maxRatio  float64
+	job       *services.Job
+
+	pending *services.PendingExport
+	writer  *csv.Writer
+	count   int64
+
+	file       string   // file being loaded
+	fileFields int      // fields per record of the file
+	order      []int    // field of the file for each column of header, nil if the same
+	fileCount  int64    // rows rejected from the file
+	fileErrors []string // first reasons rows of the file were rejected
+}
+
+func newRejects(req ingestRequest, table string, header []string, job *services.Job) *rejects {
+	return &rejects{
+		owner:     req.owner,
+		name:      table + "-rejects.csv",
+		table:     table,
+		header:    header,
+		files:     len(req.sources) > 1,
+		maxErrors: req.MaxErrors,
+		maxRatio:  req.MaxErrorRatio,
+		job:       job,
+	}
+}
+
+// setFile starts counting the rows rejected from the named file, whose
+// columns are header.
+func (r *rejects) setFile(name string, header []string) {
+	r.file, r.fileFields = name, len(header)
+	r.fileCount, r.fileErrors = 0, []string{}
+	r.order = nil
+	if slices.Equal(header, r.header) {
+		return
+	}
+	r.order = make([]int, len(r.header))
+	for i, col := range r.header {
+		r.order[i] = slices.Index(header, col)
+	}
+}
+
+// add saves a rejected record and returns an error once the rejected rows
+// exceed maxErrors. Without any limit the first rejected row is too many.
+func (r *rejects) add(line int, column, reason string, record []string) error {
+	if r.pending == nil {
+		pending, err := exportStore.Create(r.owner, r.name, r.table, nil)
+		if err != nil {
+			return fmt.Errorf("failed to save rejected rows: %v", err)
+		}
+		pending.Export.JobID = r.job.ID
+		r.pending = pending
+		r.writer = csv.NewWriter(pending)
+		header := append([]string{"line", "column", "reason"}, r.header...)
+		if r.files {
+			header = append([]string{"file"}, header...)
+		}
+		if err := r.writer.Write(header); err != nil {
+			return fmt.Errorf("failed to save rejected rows: %v", err)
+		}
+	}
+	if r.order != nil && len(record) == r.fileFields {
+		fields := make([]string, len(r.order))
+		for i, idx := range r.order {
+			if idx >= 0 {
+				fields[i] = record[idx]
+			}
+		}
+		record = fields
+	}
+	row := append([]string{strconv.Itoa(line), column, reason}, record...)
+	where := fmt.Sprintf("line %d", line)
+	if r.files {
+		row = append([]string{r.file}, row...)
+		where = r.file + " " + where
+	}
+	if err := r.writer.Write(row); err != nil {
+		return fmt.Errorf("failed to save rejected rows: %v", err)
+	}
+
+	r.count++
+	r.fileCount++
+	r.job.AddRejected(1)
+	if r.count <= maxJobErrors {
+		r.job.AddError(fmt.Sprintf("%s: %s", where, reason))
+	}
+	if len(r.fileErrors) < maxFileErrors {
+		r.fileErrors = append(r.fileErrors, fmt.Sprintf("line %d: %s", line, reason))
+	}
+	if r.maxErrors > 0 && r.count > int64(r.maxErrors) {
+		return fmt.Errorf("too many rejected rows: more than %d, last at %s: %s", r.maxErrors, where, reason)
+	}
+	if r.maxErrors == 0 && r.maxRatio == 0 {
+		return fmt.Errorf("%s: %s", where, reason)
+	}
+	return nil
+}
+
+// check returns an error if the share of rejected rows among all rows read
+// exceeds maxRatio.
+func (r *rejects) check(accepted int64) error {
+	if r.maxRatio <= 0 || r.count == 0 {
+		return nil
+	}
+	if ratio := float64(r.count) / float64(r.count+accepted); ratio > r.maxRatio {
+		return fmt.Errorf("too many rejected rows: %d of %d (%.2f%%) exceed maxErrorRatio %g", r.count, r.count+accepted, ratio*100, r.maxRatio)
+	}
+	return nil
+}
+
+// save publishes the rejects file, if any row was rejected.
+func (r *rejects) save() (*services.Export, error) {
+	if r.pending == nil {
+		return nil, nil
+	}
+	r.writer.Flush()
+	if err := r.writer.Error(); err != nil {
+		r.pending.Abort()
+		return nil, fmt.Errorf("failed to save rejected rows: %v", err)
+	}
+	return r.pending.Commit(r.count)
+}
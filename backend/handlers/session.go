@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/AditiKulkarni9/clickhouse-flatfile-tool/services"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	sessionCookie = "session_id"
+	sessionHeader = "X-Session-ID"
+
+	sessionContextKey    = "sessionKey"
+	connectionContextKey = "clickhouseConnection"
+)
+
+var connections = services.NewConnectionManager(services.DefaultIdleTimeout)
+
+// Session identifies the caller and attaches their ClickHouse connection, if
+// they have one, to the request context. Authenticated callers (see
+// RequireAuth) are keyed by their user; others by the X-Session-ID header or
+// a session cookie, which is issued on first use.
+func Session() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := sessionKey(c)
+		c.Set(sessionContextKey, key)
+		if conn, err := connections.Get(key); err == nil {
+			c.Set(connectionContextKey, conn)
+		}
+		c.Next()
+	}
+}
+
+func sessionKey(c *gin.Context) string {
+	if user := c.GetString(userContextKey); user != "" {
+		return "user:" + user
+	}
+	if id := c.GetHeader(sessionHeader); id != "" {
+		return "session:" + id
+	}
+	if id, err := c.Cookie(sessionCookie); err == nil && id != "" {
+		return "session:" + id
+	}
+	id := uuid.NewString()
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(sessionCookie, id, 0, "/", "", false, true)
+	return "session:" + id
+}
+
+// requireConnection returns the caller's connection, or responds with an
+// error and returns false when the session is not connected.
+func requireConnection(c *gin.Context) (*services.Connection, bool) {
+	if value, ok := c.Get(connectionContextKey); ok {
+		return value.(*services.Connection), true
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": "Not connected to ClickHouse"})
+	return nil, false
+}
+
+// Disconnect closes the caller's ClickHouse connection.
+func Disconnect(c *gin.Context) {
+	if err := connections.Disconnect(c.GetString(sessionContextKey)); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Disconnected"})
+}
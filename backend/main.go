@@ -2,25 +2,77 @@ package main
 
 import (
 	"log"
+	"os"
 
 	"github.com/AditiKulkarni9/clickhouse-flatfile-tool/handlers"
+	"github.com/AditiKulkarni9/clickhouse-flatfile-tool/services"
 	"github.com/gin-gonic/gin"
 )
 
 func main() {
+	// "useradd <username>" adds a user to the local user store and exits
+	if len(os.Args) > 1 && os.Args[1] == "useradd" {
+		if err := addUser(os.Args[2:]); err != nil {
+			log.Fatalf("Failed to add user: %v", err)
+		}
+		return
+	}
+
+	if err := loadKeys(); err != nil {
+		log.Fatalf("Failed to load JWT keys: %v", err)
+	}
+	go reloadKeysOnHangup()
+
+	tokens, err := newTokenService()
+	if err != nil {
+		log.Fatalf("Failed to configure authentication: %v", err)
+	}
+	handlers.SetTokenService(tokens)
+
+	exportsDir := os.Getenv("EXPORTS_DIR")
+	if exportsDir == "" {
+		exportsDir = services.DefaultExportsDir
+	}
+	exportRetention, err := durationEnv("EXPORT_RETENTION")
+	if err != nil {
+		log.Fatalf("Failed to configure exports: %v", err)
+	}
+	exports, err := services.NewExportStore(exportsDir, exportRetention)
+	if err != nil {
+		log.Fatalf("Failed to open exports directory: %v", err)
+	}
+	handlers.SetExportStore(exports)
+
+	uploadsDir := os.Getenv("UPLOADS_DIR")
+	if uploadsDir == "" {
+		uploadsDir = services.DefaultUploadsDir
+	}
+	retention, err := durationEnv("UPLOAD_RETENTION")
+	if err != nil {
+		log.Fatalf("Failed to configure uploads: %v", err)
+	}
+	uploads, err := services.NewUploadStore(uploadsDir, retention)
+	if err != nil {
+		log.Fatalf("Failed to open uploads directory: %v", err)
+	}
+	handlers.SetUploadStore(uploads)
+
 	router := setupRouter()
 	log.Println("Starting server...")
 	router.Run(":8080")
 }
 
 func setupRouter() *gin.Engine {
-	router := gin.Default()
+	router := gin.New()
+	router.Use(handlers.AccessLogger(), gin.Recovery())
 
 	// Enable CORS for frontend
 	router.Use(func(c *gin.Context) {
 		c.Header("Access-Control-Allow-Origin", "http://localhost:5173")
-		c.Header("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Content-Type")
+		c.Header("Access-Control-Allow-Methods", "GET, HEAD, POST, PUT, DELETE, OPTIONS")
+		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Session-ID, Upload-Offset")
+		c.Header("Access-Control-Expose-Headers", "Content-Disposition, Location, Upload-Offset")
+		c.Header("Access-Control-Allow-Credentials", "true")
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
 			return
@@ -28,15 +80,42 @@ func setupRouter() *gin.Engine {
 		c.Next()
 	})
 
-	// Routes
-	router.POST("/connect/clickhouse", handlers.ConnectClickHouse)
-	router.GET("/tables/clickhouse", handlers.GetClickHouseTables)
-	router.GET("/columns/clickhouse/:table", handlers.GetClickHouseColumns)
-	router.POST("/upload/flatfile", handlers.UploadFlatFile)
-	router.GET("/columns/flatfile", handlers.GetFlatFileColumns)
-	router.POST("/ingest", handlers.IngestData)
-	router.POST("/preview", handlers.PreviewData)
 	router.POST("/auth/token", handlers.GenerateJWTToken)
+	router.POST("/auth/refresh", handlers.RefreshJWTToken)
+	router.GET("/.well-known/jwks.json", handlers.JWKS)
+
+	// Data routes require a bearer token
+	api := router.Group("/", handlers.RequireAuth(), handlers.Session())
+	api.POST("/auth/logout", handlers.Logout)
+	api.POST("/connect/clickhouse", handlers.ConnectClickHouse)
+	api.POST("/disconnect", handlers.Disconnect)
+	api.GET("/databases/clickhouse", handlers.GetClickHouseDatabases)
+	api.GET("/tables/clickhouse", handlers.GetClickHouseTables)
+	api.POST("/tables/clickhouse", handlers.CreateClickHouseTable)
+	api.GET("/columns/clickhouse/:table", handlers.GetClickHouseColumns)
+	api.POST("/upload/flatfile", handlers.UploadFlatFile)
+	api.GET("/uploads", handlers.ListUploads)
+	api.GET("/uploads/:id", handlers.GetUpload)
+	api.DELETE("/uploads/:id", handlers.DeleteUpload)
+	api.POST("/uploads/chunked", handlers.StartChunkedUpload)
+	api.GET("/uploads/chunked/:id", handlers.GetChunkedUpload)
+	api.HEAD("/uploads/chunked/:id", handlers.GetChunkedUpload)
+	api.PUT("/uploads/chunked/:id", handlers.PutUploadChunk)
+	api.POST("/uploads/chunked/:id/complete", handlers.CompleteChunkedUpload)
+	api.DELETE("/uploads/chunked/:id", handlers.AbortChunkedUpload)
+	api.GET("/columns/flatfile", handlers.GetFlatFileColumns)
+	api.POST("/ingest", handlers.IngestData)
+	api.POST("/preview", handlers.PreviewData)
+	api.GET("/jobs", handlers.ListJobs)
+	api.GET("/jobs/:id", handlers.GetJob)
+	api.DELETE("/jobs/:id", handlers.CancelJob)
+	api.GET("/exports", handlers.ListExports)
+	api.GET("/exports/stream", handlers.StreamExport)
+	api.GET("/exports/:id/download", handlers.DownloadExport)
+	api.DELETE("/exports/:id", handlers.DeleteExport)
+
+	// EventSource cannot send headers, so its token may be in the query
+	router.GET("/jobs/:id/events", handlers.RequireAuthOrQueryToken(), handlers.Session(), handlers.StreamJobEvents)
 
 	return router
 }
@@ -7,10 +7,16 @@ type ClickHouseConfig struct {
 	Database string `json:"database"`
 	User     string `json:"user"`
 	Password string `json:"password"`
+	// JWTToken, when set, is the only credential sent instead of the user
+	// and password (ClickHouse Cloud JWT login). It requires Secure.
 	JWTToken string `json:"jwtToken"`
+	Secure   bool   `json:"secure"`
 }
 
 type FlatFileConfig struct {
 	FileName  string `json:"fileName"`
 	Delimiter string `json:"delimiter"`
+	// Encoding of the file, e.g. "windows-1252" or "utf-16le"; detected
+	// when empty.
+	Encoding string `json:"encoding"`
 }
@@ -0,0 +1,10 @@
+package models
+
+import "time"
+
+// User is an account in the local user store. PasswordHash is a bcrypt hash.
+type User struct {
+	Username     string    `json:"username"`
+	PasswordHash string    `json:"passwordHash"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
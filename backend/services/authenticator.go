@@ -0,0 +1,36 @@
+package services
+
+import (
+	"errors"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var ErrInvalidCredentials = errors.New("invalid username or password")
+
+// Authenticator checks a username and password. Implementations return
+// ErrInvalidCredentials for unknown users and wrong passwords alike.
+// UserExists reports whether a user is still known, for token refreshes.
+type Authenticator interface {
+	Authenticate(username, password string) error
+	UserExists(username string) (bool, error)
+}
+
+// dummyHash is compared against when a user does not exist, so unknown and
+// known users take the same time to reject.
+var dummyHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+	return hash
+})
+
+func checkBcrypt(hash []byte, password string) error {
+	if hash == nil {
+		_ = bcrypt.CompareHashAndPassword(dummyHash(), []byte(password))
+		return ErrInvalidCredentials
+	}
+	if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil {
+		return ErrInvalidCredentials
+	}
+	return nil
+}
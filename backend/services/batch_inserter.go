@@ -0,0 +1,99 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+)
+
+const (
+	// DefaultBatchRows is the number of rows buffered before a batch is sent.
+	DefaultBatchRows = 100000
+	// DefaultBatchBytes is the approximate payload size buffered before a batch is sent.
+	DefaultBatchBytes int64 = 64 << 20
+)
+
+// BatchInserter buffers rows into a native ClickHouse batch and sends it
+// whenever the row or byte limit is reached, so every flush produces a
+// single columnar INSERT instead of one statement per row.
+type BatchInserter struct {
+	conn     driver.Conn
+	query    string
+	maxRows  int
+	maxBytes int64
+
+	batch      driver.Batch
+	batchRows  int
+	batchBytes int64
+
+	Rows    int64
+	Bytes   int64
+	Batches int
+}
+
+// NewBatchInserter creates an inserter for the given table and columns. table
+// must already be quoted, e.g. with QualifiedTable; columns are quoted here.
+// Non-positive limits fall back to DefaultBatchRows and DefaultBatchBytes.
+func NewBatchInserter(conn driver.Conn, table string, columns []string, maxRows int, maxBytes int64) *BatchInserter {
+	if maxRows <= 0 {
+		maxRows = DefaultBatchRows
+	}
+	if maxBytes <= 0 {
+		maxBytes = DefaultBatchBytes
+	}
+	return &BatchInserter{
+		conn:     conn,
+		query:    fmt.Sprintf("INSERT INTO %s (%s)", table, QuoteIdentifiers(columns)),
+		maxRows:  maxRows,
+		maxBytes: maxBytes,
+	}
+}
+
+// Append adds a row to the current batch. size is the number of source bytes
+// the row was decoded from and counts towards the byte limit.
+func (b *BatchInserter) Append(ctx context.Context, values []interface{}, size int64) error {
+	if b.batch == nil {
+		batch, err := b.conn.PrepareBatch(ctx, b.query)
+		if err != nil {
+			return fmt.Errorf("failed to prepare batch: %v", err)
+		}
+		b.batch = batch
+	}
+	if err := b.batch.Append(values...); err != nil {
+		return fmt.Errorf("failed to append row: %v", err)
+	}
+	b.batchRows++
+	b.batchBytes += size
+
+	if b.batchRows >= b.maxRows || b.batchBytes >= b.maxBytes {
+		return b.Flush()
+	}
+	return nil
+}
+
+// Flush sends the buffered rows, if any.
+func (b *BatchInserter) Flush() error {
+	if b.batch == nil {
+		return nil
+	}
+	batch := b.batch
+	b.batch = nil
+	if err := batch.Send(); err != nil {
+		return fmt.Errorf("failed to send batch: %v", err)
+	}
+	b.Rows += int64(b.batchRows)
+	b.Bytes += b.batchBytes
+	b.Batches++
+	b.batchRows = 0
+	b.batchBytes = 0
+	return nil
+}
+
+// Close releases a batch that was never sent.
+func (b *BatchInserter) Close() {
+	if b.batch != nil {
+		_ = b.batch.Abort()
+		b.batch = nil
+	}
+}
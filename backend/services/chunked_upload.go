@@ -0,0 +1,339 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrOffsetMismatch   = errors.New("chunk offset does not match the upload offset")
+	ErrUploadIncomplete = errors.New("upload is not complete")
+	ErrChecksumMismatch = errors.New("sha256 checksum does not match")
+	ErrChunkTooLarge    = errors.New("chunk extends past the declared upload size")
+)
+
+// PartialUpload is a chunked upload in progress. Its state is kept on disk,
+// so an interrupted upload can continue from Offset, even after a restart.
+type PartialUpload struct {
+	ID           string    `json:"id"`
+	Owner        string    `json:"-"`
+	OriginalName string    `json:"originalName"`
+	Size         int64     `json:"size"`
+	Offset       int64     `json:"offset"`
+	Uploader     string    `json:"uploader"`
+	CreatedAt    time.Time `json:"createdAt"`
+	UpdatedAt    time.Time `json:"updatedAt"`
+	ExpiresAt    time.Time `json:"expiresAt"`
+
+	hashState []byte // marshaled SHA-256 state of the first Offset bytes
+}
+
+// partialMeta is the state file contents.
+type partialMeta struct {
+	*PartialUpload
+	Owner     string `json:"owner"`
+	HashState []byte `json:"hashState"`
+}
+
+// StartChunked begins a chunked upload of size bytes.
+func (s *UploadStore) StartChunked(owner, uploader, name string, size int64) (*PartialUpload, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("upload size must be positive")
+	}
+	if err := os.MkdirAll(s.partialDir(), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create upload: %v", err)
+	}
+	state, err := sha256.New().(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create upload: %v", err)
+	}
+
+	now := time.Now().UTC()
+	partial := &PartialUpload{
+		ID:           uuid.NewString(),
+		Owner:        owner,
+		OriginalName: filepath.Base(strings.ReplaceAll(name, `\`, "/")),
+		Size:         size,
+		Uploader:     uploader,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+		ExpiresAt:    now.Add(s.retention),
+		hashState:    state,
+	}
+	file, err := os.Create(s.partialDataPath(partial.ID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create upload: %v", err)
+	}
+	file.Close()
+	if err := s.writePartial(partial); err != nil {
+		os.Remove(s.partialDataPath(partial.ID))
+		return nil, err
+	}
+	return partial, nil
+}
+
+// GetChunked returns the state of a chunked upload of owner.
+func (s *UploadStore) GetChunked(owner, id string) (*PartialUpload, error) {
+	partial, err := s.readPartial(id)
+	if err != nil {
+		return nil, err
+	}
+	if partial.Owner != owner {
+		return nil, ErrUploadNotFound
+	}
+	return partial, nil
+}
+
+// WriteChunk appends the contents of r at offset, which must equal the
+// current offset of the upload. If r fails part way, for example because the
+// client disconnected, the bytes received so far are kept and the client can
+// continue from the returned offset.
+func (s *UploadStore) WriteChunk(owner, id string, offset int64, r io.Reader) (*PartialUpload, error) {
+	unlock := s.lock(id)
+	defer unlock()
+
+	partial, err := s.GetChunked(owner, id)
+	if err != nil {
+		return nil, err
+	}
+	if offset != partial.Offset {
+		return partial, ErrOffsetMismatch
+	}
+
+	hash := sha256.New()
+	if err := hash.(encoding.BinaryUnmarshaler).UnmarshalBinary(partial.hashState); err != nil {
+		return nil, fmt.Errorf("failed to restore upload state: %v", err)
+	}
+	file, err := os.OpenFile(s.partialDataPath(id), os.O_WRONLY, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open upload: %v", err)
+	}
+	defer file.Close()
+	// Drop anything written after the last recorded offset, e.g. by a crash.
+	if err := file.Truncate(partial.Offset); err != nil {
+		return nil, fmt.Errorf("failed to write chunk: %v", err)
+	}
+	if _, err := file.Seek(partial.Offset, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to write chunk: %v", err)
+	}
+
+	// Read one byte more than remains to notice chunks past the declared size.
+	remaining := partial.Size - partial.Offset
+	written, readErr, writeErr := copyChunk(file, hash, io.LimitReader(r, remaining+1))
+	if writeErr != nil {
+		file.Truncate(partial.Offset)
+		return nil, fmt.Errorf("failed to write chunk: %v", writeErr)
+	}
+	if written > remaining {
+		file.Truncate(partial.Offset)
+		return partial, ErrChunkTooLarge
+	}
+	if err := file.Sync(); err != nil {
+		return nil, fmt.Errorf("failed to write chunk: %v", err)
+	}
+
+	state, err := hash.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return nil, fmt.Errorf("failed to save upload state: %v", err)
+	}
+	now := time.Now().UTC()
+	partial.Offset += written
+	partial.hashState = state
+	partial.UpdatedAt = now
+	partial.ExpiresAt = now.Add(s.retention)
+	if err := s.writePartial(partial); err != nil {
+		return nil, err
+	}
+	if readErr != nil {
+		return partial, fmt.Errorf("chunk interrupted: %v", readErr)
+	}
+	return partial, nil
+}
+
+// copyChunk copies r into file and hash. On a read error everything read
+// before it has been written; a write error leaves the file in an unknown state.
+func copyChunk(file *os.File, hash io.Writer, r io.Reader) (written int64, readErr, writeErr error) {
+	buf := make([]byte, 256<<10)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if _, err := file.Write(buf[:n]); err != nil {
+				return written, nil, err
+			}
+			hash.Write(buf[:n])
+			written += int64(n)
+		}
+		if err == io.EOF {
+			return written, nil, nil
+		}
+		if err != nil {
+			return written, err, nil
+		}
+	}
+}
+
+// CompleteChunked verifies a finished chunked upload against the expected
+// SHA-256 checksum, if one is given, and turns it into a regular upload with
+// the same ID.
+func (s *UploadStore) CompleteChunked(owner, id, checksum string) (*Upload, error) {
+	unlock := s.lock(id)
+	defer unlock()
+
+	partial, err := s.GetChunked(owner, id)
+	if err != nil {
+		return nil, err
+	}
+	if partial.Offset != partial.Size {
+		return nil, ErrUploadIncomplete
+	}
+
+	hash := sha256.New()
+	if err := hash.(encoding.BinaryUnmarshaler).UnmarshalBinary(partial.hashState); err != nil {
+		return nil, fmt.Errorf("failed to restore upload state: %v", err)
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+	if checksum != "" && !strings.EqualFold(checksum, sum) {
+		return nil, ErrChecksumMismatch
+	}
+
+	sample, err := readPrefix(s.partialDataPath(id), uploadSniffBytes)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	upload := &Upload{
+		ID:           partial.ID,
+		Owner:        partial.Owner,
+		OriginalName: partial.OriginalName,
+		Size:         partial.Size,
+		SHA256:       sum,
+		Format:       DetectFileFormat(partial.OriginalName, s.partialDataPath(id), sample),
+		Uploader:     partial.Uploader,
+		CreatedAt:    now,
+		ExpiresAt:    now.Add(s.retention),
+	}
+	if err := os.Rename(s.partialDataPath(id), s.dataPath(id)); err != nil {
+		return nil, fmt.Errorf("failed to complete upload: %v", err)
+	}
+	if err := s.writeMeta(upload); err != nil {
+		os.Rename(s.dataPath(id), s.partialDataPath(id))
+		return nil, err
+	}
+	os.Remove(s.partialMetaPath(id))
+	return upload, nil
+}
+
+// AbortChunked discards a chunked upload of owner.
+func (s *UploadStore) AbortChunked(owner, id string) error {
+	unlock := s.lock(id)
+	defer unlock()
+
+	if _, err := s.GetChunked(owner, id); err != nil {
+		return err
+	}
+	return s.removePartial(id)
+}
+
+func (s *UploadStore) removePartial(id string) error {
+	if err := os.Remove(s.partialMetaPath(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete upload: %v", err)
+	}
+	if err := os.Remove(s.partialDataPath(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete upload: %v", err)
+	}
+	return nil
+}
+
+// purgePartial deletes chunked uploads that have not received data for the
+// retention period.
+func (s *UploadStore) purgePartial(now time.Time) {
+	paths, err := filepath.Glob(filepath.Join(s.partialDir(), "*.json"))
+	if err != nil {
+		log.Printf("Failed to list partial uploads for purging: %v", err)
+		return
+	}
+	for _, path := range paths {
+		id := strings.TrimSuffix(filepath.Base(path), ".json")
+		unlock := s.lock(id)
+		partial, err := s.readPartial(id)
+		if err == nil && partial.ExpiresAt.Before(now) {
+			if err := s.removePartial(id); err != nil {
+				log.Printf("Failed to purge partial upload %s: %v", id, err)
+			}
+		}
+		unlock()
+	}
+}
+
+func (s *UploadStore) writePartial(partial *PartialUpload) error {
+	meta, err := json.MarshalIndent(partialMeta{partial, partial.Owner, partial.hashState}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode upload state: %v", err)
+	}
+	// Write through a temporary file so the state is never half written.
+	tmp := s.partialMetaPath(partial.ID) + ".tmp"
+	if err := os.WriteFile(tmp, meta, 0o644); err != nil {
+		return fmt.Errorf("failed to save upload state: %v", err)
+	}
+	if err := os.Rename(tmp, s.partialMetaPath(partial.ID)); err != nil {
+		return fmt.Errorf("failed to save upload state: %v", err)
+	}
+	return nil
+}
+
+func (s *UploadStore) readPartial(id string) (*PartialUpload, error) {
+	if uuid.Validate(id) != nil {
+		return nil, ErrUploadNotFound
+	}
+	data, err := os.ReadFile(s.partialMetaPath(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrUploadNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read upload state: %v", err)
+	}
+	meta := partialMeta{PartialUpload: &PartialUpload{}}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, fmt.Errorf("failed to parse upload state: %v", err)
+	}
+	meta.PartialUpload.Owner = meta.Owner
+	meta.PartialUpload.hashState = meta.HashState
+	return meta.PartialUpload, nil
+}
+
+func readPrefix(path string, n int64) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read upload: %v", err)
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, n))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read upload: %v", err)
+	}
+	return data, nil
+}
+
+func (s *UploadStore) partialDir() string {
+	return filepath.Join(s.dir, "partial")
+}
+
+func (s *UploadStore) partialDataPath(id string) string {
+	return filepath.Join(s.partialDir(), id+".part")
+}
+
+func (s *UploadStore) partialMetaPath(id string) string {
+	return filepath.Join(s.partialDir(), id+".json")
+}
@@ -0,0 +1,222 @@
+package services
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	dsbzip2 "github.com/dsnet/compress/bzip2"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	"github.com/ulikunitz/xz"
+)
+
+// Codec is a compression format flat files can be read and written in.
+type Codec struct {
+	Name        string
+	Extension   string
+	ContentType string
+	// magic starts every file compressed with the codec. sniff, if set,
+	// checks the rest of the header when a short magic is not conclusive.
+	magic     []byte
+	sniff     func(sample []byte) bool
+	NewReader func(r io.Reader) (io.ReadCloser, error)
+	// NewWriter returns a writer whose Close finishes the compressed stream
+	// without closing w. Writers that can flush implement Flush() error.
+	NewWriter func(w io.Writer) (io.WriteCloser, error)
+}
+
+// codecs are the supported compression formats, in the order DetectCodec
+// tries them.
+var codecs = []*Codec{
+	{
+		Name:        "gzip",
+		Extension:   ".gz",
+		ContentType: "application/gzip",
+		magic:       []byte{0x1F, 0x8B},
+		NewReader:   func(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) },
+		NewWriter:   func(w io.Writer) (io.WriteCloser, error) { return gzip.NewWriter(w), nil },
+	},
+	{
+		Name:        "zstd",
+		Extension:   ".zst",
+		ContentType: "application/zstd",
+		magic:       []byte{0x28, 0xB5, 0x2F, 0xFD},
+		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+			d, err := zstd.NewReader(r)
+			if err != nil {
+				return nil, err
+			}
+			return d.IOReadCloser(), nil
+		},
+		NewWriter: func(w io.Writer) (io.WriteCloser, error) { return zstd.NewWriter(w) },
+	},
+	{
+		Name:        "lz4",
+		Extension:   ".lz4",
+		ContentType: "application/x-lz4",
+		magic:       []byte{0x04, 0x22, 0x4D, 0x18},
+		NewReader:   func(r io.Reader) (io.ReadCloser, error) { return io.NopCloser(lz4.NewReader(r)), nil },
+		NewWriter:   func(w io.Writer) (io.WriteCloser, error) { return lz4.NewWriter(w), nil },
+	},
+	{
+		Name:        "bzip2",
+		Extension:   ".bz2",
+		ContentType: "application/x-bzip2",
+		magic:       []byte("BZh"),
+		sniff:       isBzip2,
+		NewReader:   func(r io.Reader) (io.ReadCloser, error) { return io.NopCloser(bzip2.NewReader(r)), nil },
+		NewWriter:   func(w io.Writer) (io.WriteCloser, error) { return dsbzip2.NewWriter(w, nil) },
+	},
+	{
+		Name:        "xz",
+		Extension:   ".xz",
+		ContentType: "application/x-xz",
+		magic:       []byte{0xFD, '7', 'z', 'X', 'Z', 0x00},
+		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+			xr, err := xz.NewReader(r)
+			if err != nil {
+				return nil, err
+			}
+			return io.NopCloser(xr), nil
+		},
+		// xz writes its stream header as soon as the writer is created.
+		NewWriter: func(w io.Writer) (io.WriteCloser, error) {
+			return &deferredWriter{w: w, open: func(w io.Writer) (io.WriteCloser, error) { return xz.NewWriter(w) }}, nil
+		},
+	},
+}
+
+// isBzip2 checks the bzip2 header after "BZh": a block size digit from 1 to
+// 9, then the magic of the first block, or of the end of the stream for an
+// empty one. "BZh" alone also starts plenty of text files.
+func isBzip2(sample []byte) bool {
+	if len(sample) < 10 || sample[3] < '1' || sample[3] > '9' {
+		return false
+	}
+	block := sample[4:10]
+	return bytes.Equal(block, []byte("1AY&SY")) || bytes.Equal(block, []byte{0x17, 0x72, 0x45, 0x38, 0x50, 0x90})
+}
+
+// LookupCodec returns the named compression format. An empty name and
+// "none" mean no compression and return nil.
+func LookupCodec(name string) (*Codec, error) {
+	switch name = strings.ToLower(name); name {
+	case "", "none":
+		return nil, nil
+	}
+	names := make([]string, len(codecs))
+	for i, codec := range codecs {
+		if codec.Name == name {
+			return codec, nil
+		}
+		names[i] = codec.Name
+	}
+	return nil, fmt.Errorf("unknown compression %q: expected none, %s", name, strings.Join(names, ", "))
+}
+
+// DetectCodec recognises a compressed file by the magic bytes its sample
+// starts with or, failing that, by the extension of its name. It returns
+// nil for uncompressed files.
+func DetectCodec(name string, sample []byte) *Codec {
+	for _, codec := range codecs {
+		if bytes.HasPrefix(sample, codec.magic) && (codec.sniff == nil || codec.sniff(sample)) {
+			return codec
+		}
+	}
+	name = strings.ToLower(name)
+	for _, codec := range codecs {
+		if strings.HasSuffix(name, codec.Extension) {
+			return codec
+		}
+	}
+	return nil
+}
+
+// DetectFileFormat guesses the compression and format of the file at path
+// from its name and sample, its first bytes. The format of a compressed file
+// is detected from the start of the decompressed file, since a block of
+// bzip2 or lz4 can be larger than the sample.
+func DetectFileFormat(name, path string, sample []byte) FileFormat {
+	codec := DetectCodec(name, sample)
+	if codec == nil {
+		return DetectFormat(sample)
+	}
+	var text []byte
+	if r, err := openDecompressed(path, codec.Name); err == nil {
+		// A damaged stream stops with an error; what was read is used.
+		text, _ = io.ReadAll(io.LimitReader(r, uploadSniffBytes))
+		r.Close()
+	}
+	format := DetectFormat(text)
+	format.Compression = codec.Name
+	return format
+}
+
+// deferredWriter creates its compressing writer on the first write, so
+// nothing reaches w before there is data to compress.
+type deferredWriter struct {
+	w    io.Writer
+	open func(w io.Writer) (io.WriteCloser, error)
+	zw   io.WriteCloser
+}
+
+func (d *deferredWriter) Write(p []byte) (int, error) {
+	if d.zw == nil {
+		zw, err := d.open(d.w)
+		if err != nil {
+			return 0, err
+		}
+		d.zw = zw
+	}
+	return d.zw.Write(p)
+}
+
+func (d *deferredWriter) Close() error {
+	if d.zw == nil {
+		if _, err := d.Write(nil); err != nil {
+			return err
+		}
+	}
+	return d.zw.Close()
+}
+
+// compressedFile is an open file read through a decompressor.
+type compressedFile struct {
+	io.Reader
+	file *os.File
+	dec  io.Closer
+}
+
+func (f *compressedFile) Close() error {
+	if f.dec != nil {
+		f.dec.Close()
+	}
+	return f.file.Close()
+}
+
+// openDecompressed opens the file at path and decompresses it with the
+// named codec, if any.
+func openDecompressed(path, compression string) (io.ReadCloser, error) {
+	codec, err := LookupCodec(compression)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %v", err)
+	}
+	if codec == nil {
+		return file, nil
+	}
+	dec, err := codec.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to read %s file: %v", codec.Name, err)
+	}
+	return &compressedFile{Reader: dec, file: file, dec: dec}, nil
+}
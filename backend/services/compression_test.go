@@ -0,0 +1,119 @@
+package services
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// compressWith writes data through the named codec.
+func compressWith(t *testing.T, name string, data []byte) []byte {
+	t.Helper()
+	codec, err := LookupCodec(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	w, err := codec.NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	data := []byte("id,name\n1,a\n2,b\n")
+	for _, codec := range codecs {
+		for _, input := range [][]byte{data, {}} {
+			compressed := compressWith(t, codec.Name, input)
+			// zstd writes nothing at all for empty input.
+			if got := DetectCodec("upload", compressed); got != codec && len(compressed) > 0 {
+				t.Errorf("%s: %d byte input detected as %v", codec.Name, len(input), got)
+			}
+			r, err := codec.NewReader(bytes.NewReader(compressed))
+			if err != nil {
+				t.Fatalf("%s: %v", codec.Name, err)
+			}
+			got, err := io.ReadAll(r)
+			r.Close()
+			if err != nil || !bytes.Equal(got, input) {
+				t.Errorf("%s: read back %q, %v, want %q", codec.Name, got, err, input)
+			}
+		}
+	}
+}
+
+func TestDetectCodec(t *testing.T) {
+	tests := []struct {
+		name   string
+		file   string
+		sample []byte
+		want   string // empty for uncompressed
+	}{
+		{"gzip magic", "data.csv", []byte{0x1F, 0x8B, 0x08, 0x00}, "gzip"},
+		{"zstd magic", "data.csv", []byte{0x28, 0xB5, 0x2F, 0xFD, 0x00}, "zstd"},
+		{"lz4 magic", "data.csv", []byte{0x04, 0x22, 0x4D, 0x18, 0x64}, "lz4"},
+		{"xz magic", "data.csv", []byte{0xFD, '7', 'z', 'X', 'Z', 0x00, 0x00}, "xz"},
+		{"bzip2 block", "data.csv", []byte("BZh91AY&SY\x00\x00"), "bzip2"},
+		{"bzip2 empty stream", "data.csv", []byte("BZh9\x17\x72\x45\x38\x50\x90"), "bzip2"},
+		{"text starting with BZh", "data.csv", []byte("BZh,name\n1,2\n"), ""},
+		{"BZh without block size", "data.csv", []byte("BZh01AY&SY"), ""},
+		{"BZh with a digit but no block", "data.csv", []byte("BZh9 is a name\n"), ""},
+		{"short bzip2 sample", "data.csv", []byte("BZh9"), ""},
+		{"magic wins over extension", "data.gz", []byte{0x28, 0xB5, 0x2F, 0xFD}, "zstd"},
+		{"extension", "DATA.CSV.BZ2", []byte("BZh,not a header"), "bzip2"},
+		{"zst extension", "data.zst", []byte("id\n"), "zstd"},
+		{"plain", "data.csv", []byte("id,name\n"), ""},
+		{"empty", "data", nil, ""},
+	}
+	for _, tt := range tests {
+		got := ""
+		if codec := DetectCodec(tt.file, tt.sample); codec != nil {
+			got = codec.Name
+		}
+		if got != tt.want {
+			t.Errorf("%s: DetectCodec = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDetectFileFormatCompressed(t *testing.T) {
+	data := bytes.Repeat([]byte("id;name\r\n1;a\r\n2;b\r\n"), 100)
+	for _, codec := range codecs {
+		compressed := compressWith(t, codec.Name, data)
+		path := filepath.Join(t.TempDir(), "upload")
+		if err := os.WriteFile(path, compressed, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		// The sample holds only part of the first compressed block.
+		sample := compressed[:len(compressed)*3/4]
+		want := csvFormat(func(f *FileFormat) {
+			f.Delimiter, f.LineEnding, f.Compression = ";", "\r\n", codec.Name
+		})
+		if got := DetectFileFormat("upload", path, sample); got != want {
+			t.Errorf("%s: DetectFileFormat = %+v, want %+v", codec.Name, got, want)
+		}
+	}
+}
+
+func TestLookupCodec(t *testing.T) {
+	for _, name := range []string{"", "none", "NONE"} {
+		if codec, err := LookupCodec(name); codec != nil || err != nil {
+			t.Errorf("LookupCodec(%q) = %v, %v, want no codec", name, codec, err)
+		}
+	}
+	if codec, err := LookupCodec("ZSTD"); err != nil || codec.Name != "zstd" {
+		t.Errorf("LookupCodec(ZSTD) = %v, %v", codec, err)
+	}
+	if _, err := LookupCodec("rar"); err == nil {
+		t.Error("LookupCodec(rar) succeeded")
+	}
+}
@@ -0,0 +1,173 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/AditiKulkarni9/clickhouse-flatfile-tool/models"
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+)
+
+const (
+	// DefaultIdleTimeout is how long an unused session connection is kept open.
+	DefaultIdleTimeout = 30 * time.Minute
+	// DefaultMaxOpenConns bounds the driver pool of each session connection.
+	DefaultMaxOpenConns = 10
+	// DefaultMaxIdleConns is the number of pooled connections kept warm per session.
+	DefaultMaxIdleConns = 5
+)
+
+var ErrNotConnected = errors.New("not connected to ClickHouse")
+
+// Connection is a pooled ClickHouse connection owned by one session.
+type Connection struct {
+	Conn     driver.Conn
+	Database string
+	Host     string
+	User     string
+
+	refs     int
+	closed   bool
+	lastUsed time.Time
+}
+
+// ConnectionManager keeps one ClickHouse connection per session key and
+// closes connections that have been idle longer than the idle timeout.
+type ConnectionManager struct {
+	mu          sync.Mutex
+	conns       map[string]*Connection
+	idleTimeout time.Duration
+}
+
+// NewConnectionManager starts a manager that evicts idle connections.
+func NewConnectionManager(idleTimeout time.Duration) *ConnectionManager {
+	if idleTimeout <= 0 {
+		idleTimeout = DefaultIdleTimeout
+	}
+	m := &ConnectionManager{
+		conns:       make(map[string]*Connection),
+		idleTimeout: idleTimeout,
+	}
+	go m.evictLoop()
+	return m
+}
+
+// Connect opens and pings a connection for the session, replacing any
+// connection the session already had.
+func (m *ConnectionManager) Connect(ctx context.Context, key string, config models.ClickHouseConfig) (*Connection, error) {
+	conn, err := clickhouse.Open(clickhouseOptions(config))
+	if err != nil {
+		return nil, fmt.Errorf("connection failed: %v", err)
+	}
+	if err := conn.Ping(ctx); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("ping failed: %v", err)
+	}
+
+	database := config.Database
+	if database == "" {
+		database = DefaultDatabase
+	}
+	c := &Connection{
+		Conn:     conn,
+		Database: database,
+		Host:     config.Host,
+		User:     config.User,
+		lastUsed: time.Now(),
+	}
+
+	m.mu.Lock()
+	old := m.conns[key]
+	m.conns[key] = c
+	m.mu.Unlock()
+	if old != nil {
+		m.release(old, true)
+	}
+	return c, nil
+}
+
+// Get returns the session's connection and marks it as used.
+func (m *ConnectionManager) Get(key string) (*Connection, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, ok := m.conns[key]
+	if !ok {
+		return nil, ErrNotConnected
+	}
+	c.lastUsed = time.Now()
+	return c, nil
+}
+
+// Acquire returns the session's connection and keeps it open, even across
+// Disconnect or idle eviction, until the returned release func is called.
+func (m *ConnectionManager) Acquire(key string) (*Connection, func(), error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, ok := m.conns[key]
+	if !ok {
+		return nil, nil, ErrNotConnected
+	}
+	c.refs++
+	c.lastUsed = time.Now()
+	var once sync.Once
+	return c, func() { once.Do(func() { m.release(c, false) }) }, nil
+}
+
+// Disconnect closes the session's connection once it is no longer in use.
+func (m *ConnectionManager) Disconnect(key string) error {
+	m.mu.Lock()
+	c, ok := m.conns[key]
+	if ok {
+		delete(m.conns, key)
+	}
+	m.mu.Unlock()
+	if !ok {
+		return ErrNotConnected
+	}
+	m.release(c, true)
+	return nil
+}
+
+// release drops a reference, or retires the connection when retire is set,
+// and closes it when it is both retired and unreferenced.
+func (m *ConnectionManager) release(c *Connection, retire bool) {
+	m.mu.Lock()
+	if retire {
+		c.closed = true
+	} else {
+		c.refs--
+		c.lastUsed = time.Now()
+	}
+	closeNow := c.closed && c.refs == 0
+	m.mu.Unlock()
+	if closeNow {
+		c.Conn.Close()
+	}
+}
+
+func (m *ConnectionManager) evictLoop() {
+	ticker := time.NewTicker(m.idleTimeout / 4)
+	defer ticker.Stop()
+	for range ticker.C {
+		m.evictIdle()
+	}
+}
+
+func (m *ConnectionManager) evictIdle() {
+	var idle []*Connection
+	m.mu.Lock()
+	for key, c := range m.conns {
+		if c.refs == 0 && time.Since(c.lastUsed) > m.idleTimeout {
+			delete(m.conns, key)
+			idle = append(idle, c)
+		}
+	}
+	m.mu.Unlock()
+	for _, c := range idle {
+		m.release(c, true)
+	}
+}
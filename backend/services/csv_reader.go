@@ -0,0 +1,187 @@
+package services
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrFieldCount        = errors.New("wrong number of fields")
+	ErrUnterminatedQuote = errors.New("quoted field is not terminated")
+)
+
+// CSVReader reads the records of a delimited file in a given FileFormat.
+// Unlike encoding/csv it accepts any quote character, or none, and lone
+// carriage returns as line endings. Like encoding/csv it skips empty lines
+// and expects every record to have as many fields as the first.
+type CSVReader struct {
+	r         *bufio.Reader
+	format    FileFormat
+	delimiter string
+	quote     string
+	term      byte
+
+	line     int      // line the last record started on
+	nextLine int      // line the next record starts on
+	fields   int      // fields per record, set by the first record
+	pending  []string // first record of a file without header, returned again by Read
+	started  bool
+}
+
+// NewCSVReader returns a reader of r, which is transcoded to UTF-8 from the
+// encoding of the format. A byte order mark at the start of r is skipped.
+func NewCSVReader(r io.Reader, format FileFormat) *CSVReader {
+	br := bufio.NewReaderSize(DecodeReader(r, format.Encoding), 64<<10)
+	if bom, err := br.Peek(3); err == nil && string(bom) == "\xEF\xBB\xBF" {
+		br.Discard(3)
+	}
+	term := byte('\n')
+	if format.LineEnding == "\r" {
+		term = '\r'
+	}
+	return &CSVReader{
+		r:         br,
+		format:    format,
+		delimiter: format.Delimiter,
+		quote:     format.Quote,
+		term:      term,
+		nextLine:  1,
+	}
+}
+
+// Header returns the column names: the first record if the file has a
+// header, otherwise c1, c2, ... for the fields of the first record, which
+// the next Read then returns. It must be called before Read.
+func (r *CSVReader) Header() ([]string, error) {
+	if r.started {
+		return nil, fmt.Errorf("header must be read before the records")
+	}
+	record, err := r.Read()
+	if err != nil {
+		return nil, err
+	}
+	if r.format.HasHeader {
+		return record, nil
+	}
+	r.pending = record
+	names := make([]string, len(record))
+	for i := range names {
+		names[i] = "c" + strconv.Itoa(i+1)
+	}
+	return names, nil
+}
+
+// Line returns the line number the last record read started on.
+func (r *CSVReader) Line() int {
+	return r.line
+}
+
+// Read returns the next record, or io.EOF at the end of the file. A record
+// with the wrong number of fields is returned together with ErrFieldCount.
+func (r *CSVReader) Read() ([]string, error) {
+	r.started = true
+	if r.pending != nil {
+		record := r.pending
+		r.pending = nil
+		return record, nil
+	}
+
+	record, err := r.readRecord()
+	if err != nil {
+		return nil, err
+	}
+	if r.fields == 0 {
+		r.fields = len(record)
+	} else if len(record) != r.fields {
+		return record, fmt.Errorf("line %d: %w: expected %d, got %d", r.line, ErrFieldCount, r.fields, len(record))
+	}
+	return record, nil
+}
+
+func (r *CSVReader) readRecord() ([]string, error) {
+	var line string
+	for {
+		var err error
+		r.line = r.nextLine
+		line, err = r.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if line != "" {
+			break
+		}
+	}
+
+	var record []string
+	pos := 0
+	for {
+		if r.quote == "" || !strings.HasPrefix(line[pos:], r.quote) {
+			end := strings.Index(line[pos:], r.delimiter)
+			if end < 0 {
+				return append(record, line[pos:]), nil
+			}
+			record = append(record, line[pos:pos+end])
+			pos += end + len(r.delimiter)
+			continue
+		}
+
+		// Quoted field: a doubled quote stands for the quote itself, and the
+		// field may continue over several lines.
+		var field strings.Builder
+		pos += len(r.quote)
+		for {
+			end := strings.Index(line[pos:], r.quote)
+			if end < 0 {
+				field.WriteString(line[pos:])
+				field.WriteByte('\n')
+				next, err := r.readLine()
+				if err == io.EOF {
+					return nil, fmt.Errorf("line %d: %w", r.line, ErrUnterminatedQuote)
+				}
+				if err != nil {
+					return nil, err
+				}
+				line, pos = next, 0
+				continue
+			}
+			field.WriteString(line[pos : pos+end])
+			pos += end + len(r.quote)
+			if strings.HasPrefix(line[pos:], r.quote) {
+				field.WriteString(r.quote)
+				pos += len(r.quote)
+				continue
+			}
+			break
+		}
+		// Text between the closing quote and the delimiter is kept as is.
+		end := strings.Index(line[pos:], r.delimiter)
+		if end < 0 {
+			field.WriteString(line[pos:])
+			return append(record, field.String()), nil
+		}
+		field.WriteString(line[pos : pos+end])
+		record = append(record, field.String())
+		pos += end + len(r.delimiter)
+	}
+}
+
+// readLine returns the next line without its line ending.
+func (r *CSVReader) readLine() (string, error) {
+	line, err := r.r.ReadString(r.term)
+	if err == io.EOF && line != "" {
+		err = nil
+	}
+	if err != nil {
+		return "", err
+	}
+	r.nextLine++
+	line = strings.TrimSuffix(line, string(r.term))
+	if r.term == '\n' {
+		line = strings.TrimSuffix(line, "\r")
+	}
+	return line, nil
+}
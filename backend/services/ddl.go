@@ -0,0 +1,181 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/AditiKulkarni9/clickhouse-flatfile-tool/chtypes"
+	"github.com/AditiKulkarni9/clickhouse-flatfile-tool/models"
+)
+
+// DefaultTableEngine is used when a TableSpec names no engine.
+const DefaultTableEngine = "MergeTree"
+
+// tableEngines are the engines a table may be created with. The MergeTree
+// family takes ORDER BY, PARTITION BY and TTL clauses; the others do not.
+var tableEngines = map[string]bool{
+	"MergeTree":                    true,
+	"ReplacingMergeTree":           true,
+	"SummingMergeTree":             true,
+	"AggregatingMergeTree":         true,
+	"CollapsingMergeTree":          true,
+	"VersionedCollapsingMergeTree": true,
+	"Log":                          false,
+	"TinyLog":                      false,
+	"StripeLog":                    false,
+	"Memory":                       false,
+}
+
+// TableSpec describes a table to create. OrderBy, PartitionBy and TTL are
+// ClickHouse expressions; an OrderBy entry that names a column is quoted.
+// EngineArgs name columns, such as the version column of a
+// ReplacingMergeTree or the sign column of a CollapsingMergeTree.
+type TableSpec struct {
+	Columns     []models.Column `json:"columns"`
+	Engine      string          `json:"engine"`
+	EngineArgs  []string        `json:"engineArgs"`
+	OrderBy     []string        `json:"orderBy"`
+	PartitionBy string          `json:"partitionBy"`
+	TTL         string          `json:"ttl"`
+	IfNotExists bool            `json:"ifNotExists"`
+}
+
+// DDL returns the CREATE TABLE statement for the table database.table.
+func (s TableSpec) DDL(database, table string) (string, error) {
+	if table == "" {
+		return "", fmt.Errorf("table name is required")
+	}
+	if len(s.Columns) == 0 {
+		return "", fmt.Errorf("table needs at least one column")
+	}
+	engine := s.Engine
+	if engine == "" {
+		engine = DefaultTableEngine
+	}
+	mergeTree, ok := tableEngines[engine]
+	if !ok {
+		return "", fmt.Errorf("unsupported table engine %q", engine)
+	}
+
+	columns := make(map[string]bool, len(s.Columns))
+	definitions := make([]string, len(s.Columns))
+	for i, col := range s.Columns {
+		if col.Name == "" {
+			return "", fmt.Errorf("column %d has no name", i+1)
+		}
+		if columns[col.Name] {
+			return "", fmt.Errorf("duplicate column %s", col.Name)
+		}
+		columns[col.Name] = true
+		typ, err := chtypes.Parse(col.Type)
+		if err != nil {
+			return "", fmt.Errorf("column %s: %v", col.Name, err)
+		}
+		if typ.Kind == chtypes.Unknown {
+			return "", fmt.Errorf("column %s: unsupported type %s", col.Name, col.Type)
+		}
+		definitions[i] = "    " + QuoteIdentifier(col.Name) + " " + typ.String()
+	}
+
+	var b strings.Builder
+	b.WriteString("CREATE TABLE ")
+	if s.IfNotExists {
+		b.WriteString("IF NOT EXISTS ")
+	}
+	b.WriteString(QualifiedTable(database, table))
+	b.WriteString("\n(\n" + strings.Join(definitions, ",\n") + "\n)\n")
+
+	b.WriteString("ENGINE = " + engine)
+	if len(s.EngineArgs) > 0 {
+		args := make([]string, len(s.EngineArgs))
+		for i, arg := range s.EngineArgs {
+			if !columns[arg] {
+				return "", fmt.Errorf("engine argument %s is not a column", arg)
+			}
+			args[i] = QuoteIdentifier(arg)
+		}
+		b.WriteString("(" + strings.Join(args, ", ") + ")")
+	}
+
+	if !mergeTree {
+		if len(s.OrderBy) > 0 || s.PartitionBy != "" || s.TTL != "" {
+			return "", fmt.Errorf("%s tables take no ORDER BY, PARTITION BY or TTL", engine)
+		}
+		return b.String(), nil
+	}
+
+	if s.PartitionBy != "" {
+		expr, err := tableExpression(s.PartitionBy, columns)
+		if err != nil {
+			return "", fmt.Errorf("invalid PARTITION BY: %v", err)
+		}
+		b.WriteString("\nPARTITION BY " + expr)
+	}
+	orderBy := make([]string, len(s.OrderBy))
+	for i, key := range s.OrderBy {
+		expr, err := tableExpression(key, columns)
+		if err != nil {
+			return "", fmt.Errorf("invalid ORDER BY: %v", err)
+		}
+		orderBy[i] = expr
+	}
+	switch len(orderBy) {
+	case 0:
+		b.WriteString("\nORDER BY tuple()")
+	case 1:
+		b.WriteString("\nORDER BY " + orderBy[0])
+	default:
+		b.WriteString("\nORDER BY (" + strings.Join(orderBy, ", ") + ")")
+	}
+	if s.TTL != "" {
+		expr, err := tableExpression(s.TTL, columns)
+		if err != nil {
+			return "", fmt.Errorf("invalid TTL: %v", err)
+		}
+		b.WriteString("\nTTL " + expr)
+	}
+	return b.String(), nil
+}
+
+// tableExpression quotes expr if it names a column, and otherwise checks
+// that it is a single balanced expression.
+func tableExpression(expr string, columns map[string]bool) (string, error) {
+	expr = strings.TrimSpace(expr)
+	if columns[expr] {
+		return QuoteIdentifier(expr), nil
+	}
+	if expr == "" {
+		return "", fmt.Errorf("empty expression")
+	}
+
+	depth := 0
+	var quote byte
+	for i := 0; i < len(expr); i++ {
+		ch := expr[i]
+		switch {
+		case quote != 0:
+			if ch == '\\' {
+				i++
+			} else if ch == quote {
+				quote = 0
+			}
+		case ch == '\'' || ch == '`' || ch == '"':
+			quote = ch
+		case ch == '(':
+			depth++
+		case ch == ')':
+			depth--
+			if depth < 0 {
+				return "", fmt.Errorf("unbalanced parentheses in %q", expr)
+			}
+		case ch == ';':
+			return "", fmt.Errorf("unexpected ';' in %q", expr)
+		case ch == '-' && i+1 < len(expr) && expr[i+1] == '-', ch == '/' && i+1 < len(expr) && expr[i+1] == '*':
+			return "", fmt.Errorf("comments are not allowed in %q", expr)
+		}
+	}
+	if depth != 0 || quote != 0 {
+		return "", fmt.Errorf("unbalanced expression %q", expr)
+	}
+	return expr, nil
+}
@@ -0,0 +1,78 @@
+package services
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+// textEncodings are the encodings flat files may use besides UTF-8. The
+// UTF-16 decoders follow a byte order mark if there is one, and the encoders
+// write one.
+var textEncodings = map[string]encoding.Encoding{
+	"utf-16le":     unicode.UTF16(unicode.LittleEndian, unicode.UseBOM),
+	"utf-16be":     unicode.UTF16(unicode.BigEndian, unicode.UseBOM),
+	"iso-8859-1":   charmap.ISO8859_1,
+	"windows-1252": charmap.Windows1252,
+}
+
+var encodingAliases = map[string]string{
+	"utf8":      "utf-8",
+	"utf16le":   "utf-16le",
+	"utf16be":   "utf-16be",
+	"latin1":    "iso-8859-1",
+	"latin-1":   "iso-8859-1",
+	"iso8859-1": "iso-8859-1",
+	"cp1252":    "windows-1252",
+}
+
+// NormalizeEncoding returns the canonical name of a supported encoding. An
+// empty name means UTF-8.
+func NormalizeEncoding(name string) (string, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if alias, ok := encodingAliases[name]; ok {
+		name = alias
+	}
+	if name == "" || name == "utf-8" {
+		return "utf-8", nil
+	}
+	if _, ok := textEncodings[name]; !ok {
+		return "", fmt.Errorf("unsupported encoding %q", name)
+	}
+	return name, nil
+}
+
+// DecodeReader transcodes r from the named encoding to UTF-8. UTF-8 input,
+// and input in an unsupported encoding, is returned unchanged.
+func DecodeReader(r io.Reader, name string) io.Reader {
+	name, err := NormalizeEncoding(name)
+	if err != nil || name == "utf-8" {
+		return r
+	}
+	return transform.NewReader(r, textEncodings[name].NewDecoder())
+}
+
+// EncodeWriter returns a writer that transcodes UTF-8 to the named encoding
+// before writing to w. Close must be called after the last write; it does
+// not close w. Characters the encoding cannot represent fail the write.
+func EncodeWriter(w io.Writer, name string) (io.WriteCloser, error) {
+	name, err := NormalizeEncoding(name)
+	if err != nil {
+		return nil, err
+	}
+	if name == "utf-8" {
+		return nopWriteCloser{w}, nil
+	}
+	return transform.NewWriter(w, textEncodings[name].NewEncoder()), nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
@@ -0,0 +1,68 @@
+package services
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+
+	"github.com/AditiKulkarni9/clickhouse-flatfile-tool/chtypes"
+)
+
+// csvRowWriter writes an export as CSV with a header line.
+type csvRowWriter struct {
+	columns []ExportColumn
+	format  chtypes.CompositeFormat
+	out     io.WriteCloser
+	writer  *csv.Writer
+}
+
+func newCSVRowWriter(w io.Writer, columns []ExportColumn, opts ExportOptions) (RowWriter, error) {
+	out, err := EncodeWriter(w, opts.Encoding)
+	if err != nil {
+		return nil, err
+	}
+	c := &csvRowWriter{columns: columns, format: opts.CompositeFormat, out: out, writer: csv.NewWriter(out)}
+	header := make([]string, len(columns))
+	for i, col := range columns {
+		header[i] = col.Name
+	}
+	if err := c.writer.Write(header); err != nil {
+		return nil, fmt.Errorf("failed to write CSV header: %v", err)
+	}
+	return c, nil
+}
+
+func (c *csvRowWriter) WriteRow(values []interface{}) (int64, error) {
+	row := make([]string, len(c.columns))
+	size := int64(len(row))
+	for i, col := range c.columns {
+		field, err := col.Type.EncodeWith(values[i], c.format)
+		if err != nil {
+			return 0, fmt.Errorf("column %s: %v", col.Name, err)
+		}
+		row[i] = field
+		size += int64(len(field))
+	}
+	if err := c.writer.Write(row); err != nil {
+		return 0, fmt.Errorf("failed to write CSV row: %v", err)
+	}
+	return size, nil
+}
+
+func (c *csvRowWriter) Flush() error {
+	c.writer.Flush()
+	if err := c.writer.Error(); err != nil {
+		return fmt.Errorf("failed to write CSV: %v", err)
+	}
+	return nil
+}
+
+func (c *csvRowWriter) Close() error {
+	if err := c.Flush(); err != nil {
+		return err
+	}
+	if err := c.out.Close(); err != nil {
+		return fmt.Errorf("failed to write CSV: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package services
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/AditiKulkarni9/clickhouse-flatfile-tool/chtypes"
+)
+
+// ExportColumn is a column of an export with its ClickHouse type.
+type ExportColumn struct {
+	Name string
+	Type *chtypes.Type
+}
+
+// ExportOptions tune how a format writes rows. Each format reads the
+// options that apply to it and ignores the rest.
+type ExportOptions struct {
+	// Encoding is the character encoding of text formats, UTF-8 by default.
+	Encoding string
+	// CompositeFormat is how text formats write Array, Map and Tuple values.
+	CompositeFormat chtypes.CompositeFormat
+	// RowGroupSize is the number of rows per Parquet row group.
+	RowGroupSize int
+	// Compression is the Parquet column compression.
+	Compression string
+}
+
+// RowWriter writes exported rows in one file format.
+type RowWriter interface {
+	// WriteRow writes the values of one row, as scanned by clickhouse-go into
+	// the targets returned by chtypes.Type.ScanTarget, and returns roughly how
+	// many bytes of data it held.
+	WriteRow(values []interface{}) (int64, error)
+	// Flush passes buffered rows on to the underlying writer, as far as the
+	// format allows before it is closed.
+	Flush() error
+	// Close writes what remains, such as a file footer. It does not close the
+	// underlying writer.
+	Close() error
+}
+
+// ExportFormat is a file format exports can be written in.
+type ExportFormat struct {
+	Name        string
+	Extension   string
+	ContentType string
+	// Text formats are written in ExportOptions.Encoding.
+	Text      bool
+	NewWriter func(w io.Writer, columns []ExportColumn, opts ExportOptions) (RowWriter, error)
+}
+
+// exportFormats are the formats exports can be written in, by name. A new
+// format only needs a RowWriter and an entry here.
+var exportFormats = map[string]*ExportFormat{
+	"csv": {
+		Name:        "csv",
+		Extension:   ".csv",
+		ContentType: "text/csv",
+		Text:        true,
+		NewWriter:   newCSVRowWriter,
+	},
+	"ndjson": {
+		Name:        "ndjson",
+		Extension:   ".ndjson",
+		ContentType: "application/x-ndjson",
+		NewWriter:   newNDJSONRowWriter,
+	},
+	"parquet": {
+		Name:        "parquet",
+		Extension:   ".parquet",
+		ContentType: "application/vnd.apache.parquet",
+		NewWriter:   newParquetRowWriter,
+	},
+}
+
+// LookupExportFormat returns the named export format. An empty name selects
+// CSV.
+func LookupExportFormat(name string) (*ExportFormat, error) {
+	if name == "" {
+		name = "csv"
+	}
+	if format, ok := exportFormats[strings.ToLower(name)]; ok {
+		return format, nil
+	}
+	names := make([]string, 0, len(exportFormats))
+	for name := range exportFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return nil, fmt.Errorf("unknown export format %q: expected one of %s", name, strings.Join(names, ", "))
+}
@@ -0,0 +1,72 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// ndjsonRowWriter writes an export as JSON Lines: one object per row, with
+// the columns as keys in their selected order. Numbers and booleans stay
+// JSON numbers and booleans, Arrays become arrays and Maps and named Tuples
+// objects.
+type ndjsonRowWriter struct {
+	columns []ExportColumn
+	keys    [][]byte
+	out     *bufio.Writer
+	buf     bytes.Buffer
+	enc     *json.Encoder
+}
+
+func newNDJSONRowWriter(w io.Writer, columns []ExportColumn, opts ExportOptions) (RowWriter, error) {
+	n := &ndjsonRowWriter{columns: columns, out: bufio.NewWriter(w)}
+	n.enc = json.NewEncoder(&n.buf)
+	n.enc.SetEscapeHTML(false)
+	for _, col := range columns {
+		key, err := json.Marshal(col.Name)
+		if err != nil {
+			return nil, err
+		}
+		n.keys = append(n.keys, key)
+	}
+	return n, nil
+}
+
+func (n *ndjsonRowWriter) WriteRow(values []interface{}) (int64, error) {
+	n.buf.Reset()
+	n.buf.WriteByte('{')
+	for i, col := range n.columns {
+		value, err := col.Type.JSONValue(values[i])
+		if err != nil {
+			return 0, fmt.Errorf("column %s: %v", col.Name, err)
+		}
+		if i > 0 {
+			n.buf.WriteByte(',')
+		}
+		n.buf.Write(n.keys[i])
+		n.buf.WriteByte(':')
+		if err := n.enc.Encode(value); err != nil {
+			return 0, fmt.Errorf("column %s: %v", col.Name, err)
+		}
+		// Encode ends every value with a newline.
+		n.buf.Truncate(n.buf.Len() - 1)
+	}
+	n.buf.WriteString("}\n")
+	if _, err := n.out.Write(n.buf.Bytes()); err != nil {
+		return 0, fmt.Errorf("failed to write JSON row: %v", err)
+	}
+	return int64(n.buf.Len()), nil
+}
+
+func (n *ndjsonRowWriter) Flush() error {
+	if err := n.out.Flush(); err != nil {
+		return fmt.Errorf("failed to write JSON: %v", err)
+	}
+	return nil
+}
+
+func (n *ndjsonRowWriter) Close() error {
+	return n.Flush()
+}
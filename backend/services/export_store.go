@@ -0,0 +1,293 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// DefaultExportsDir is where saved exports are kept when EXPORTS_DIR is unset.
+	DefaultExportsDir = "exports"
+	// DefaultExportRetention is how long exports are kept before they are purged.
+	DefaultExportRetention = 7 * 24 * time.Hour
+)
+
+var ErrExportNotFound = errors.New("export not found")
+
+// Export describes a saved export file.
+type Export struct {
+	ID    string `json:"id"`
+	Owner string `json:"-"`
+	Name  string `json:"name"`
+	Table string `json:"table"`
+	Rows  int64  `json:"rows"`
+	Size  int64  `json:"size"`
+	// Compression is the codec the export is compressed with, if any. Gzip
+	// is also set for gzip, as it was before other codecs were supported.
+	Compression string    `json:"compression,omitempty"`
+	Gzip        bool      `json:"gzip"`
+	JobID       string    `json:"jobId,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+}
+
+// ExportStore keeps exports in a single directory. Files are named by export
+// ID, never by client input, so clients cannot write outside the directory.
+// Each export has a data file and a JSON metadata file. Exports older than
+// the retention period are purged.
+type ExportStore struct {
+	dir       string
+	retention time.Duration
+}
+
+// NewExportStore creates the store, and its directory if needed, and starts
+// purging expired exports.
+func NewExportStore(dir string, retention time.Duration) (*ExportStore, error) {
+	if retention <= 0 {
+		retention = DefaultExportRetention
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create exports directory: %v", err)
+	}
+	s := &ExportStore{dir: dir, retention: retention}
+	go s.purgeLoop()
+	return s, nil
+}
+
+// PendingExport is an export being written. It becomes visible to List and
+// Open only after Commit.
+type PendingExport struct {
+	*os.File
+	Export *Export
+	store  *ExportStore
+}
+
+// Create starts a new export for owner. name is the file name offered for
+// download; any directory components are dropped, and the extension of
+// codec is added if it is compressed.
+func (s *ExportStore) Create(owner, name, table string, codec *Codec) (*PendingExport, error) {
+	name = filepath.Base(strings.ReplaceAll(name, `\`, "/"))
+	if name == "." || name == "/" || name == "" {
+		name = "export.csv"
+	}
+	now := time.Now().UTC()
+	export := &Export{
+		ID:        uuid.NewString(),
+		Owner:     owner,
+		Name:      name,
+		Table:     table,
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.retention),
+	}
+	if codec != nil {
+		if !strings.HasSuffix(name, codec.Extension) {
+			export.Name += codec.Extension
+		}
+		export.Gzip = codec.Name == "gzip"
+		export.Compression = codec.Name
+	}
+	file, err := os.Create(s.dataPath(export.ID) + ".part")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create export file: %v", err)
+	}
+	return &PendingExport{File: file, Export: export, store: s}, nil
+}
+
+// Commit closes the file and publishes the export.
+func (p *PendingExport) Commit(rows int64) (*Export, error) {
+	if err := p.File.Close(); err != nil {
+		p.Abort()
+		return nil, fmt.Errorf("failed to write export file: %v", err)
+	}
+	info, err := os.Stat(p.File.Name())
+	if err != nil {
+		p.Abort()
+		return nil, fmt.Errorf("failed to write export file: %v", err)
+	}
+	p.Export.Rows = rows
+	p.Export.Size = info.Size()
+	p.Export.ExpiresAt = time.Now().UTC().Add(p.store.retention)
+
+	meta, err := json.MarshalIndent(exportMeta{p.Export, p.Export.Owner}, "", "  ")
+	if err != nil {
+		p.Abort()
+		return nil, fmt.Errorf("failed to encode export metadata: %v", err)
+	}
+	if err := os.Rename(p.File.Name(), p.store.dataPath(p.Export.ID)); err != nil {
+		p.Abort()
+		return nil, fmt.Errorf("failed to write export file: %v", err)
+	}
+	if err := os.WriteFile(p.store.metaPath(p.Export.ID), meta, 0o644); err != nil {
+		os.Remove(p.store.dataPath(p.Export.ID))
+		return nil, fmt.Errorf("failed to write export metadata: %v", err)
+	}
+	return p.Export, nil
+}
+
+// Abort discards a pending export.
+func (p *PendingExport) Abort() {
+	p.File.Close()
+	os.Remove(p.File.Name())
+}
+
+// exportMeta is the metadata file contents. Owner is stored here but kept out
+// of API responses.
+type exportMeta struct {
+	*Export
+	Owner string `json:"owner"`
+}
+
+// List returns the saved exports of owner, newest first.
+func (s *ExportStore) List(owner string) ([]*Export, error) {
+	all, err := s.all()
+	if err != nil {
+		return nil, err
+	}
+	exports := make([]*Export, 0, len(all))
+	for _, export := range all {
+		if export.Owner == owner {
+			exports = append(exports, export)
+		}
+	}
+	return exports, nil
+}
+
+// all returns the saved exports of every owner, newest first.
+func (s *ExportStore) all() ([]*Export, error) {
+	paths, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	exports := make([]*Export, 0, len(paths))
+	for _, path := range paths {
+		export, err := s.readMeta(strings.TrimSuffix(filepath.Base(path), ".json"))
+		if err != nil {
+			continue
+		}
+		exports = append(exports, export)
+	}
+	sort.Slice(exports, func(i, j int) bool { return exports[i].CreatedAt.After(exports[j].CreatedAt) })
+	return exports, nil
+}
+
+// Get returns the metadata of an export. Exports of other owners are not found.
+func (s *ExportStore) Get(owner, id string) (*Export, error) {
+	export, err := s.readMeta(id)
+	if err != nil {
+		return nil, err
+	}
+	if export.Owner != owner {
+		return nil, ErrExportNotFound
+	}
+	return export, nil
+}
+
+func (s *ExportStore) readMeta(id string) (*Export, error) {
+	if uuid.Validate(id) != nil {
+		return nil, ErrExportNotFound
+	}
+	data, err := os.ReadFile(s.metaPath(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrExportNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read export metadata: %v", err)
+	}
+	meta := exportMeta{Export: &Export{}}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, fmt.Errorf("failed to parse export metadata: %v", err)
+	}
+	meta.Export.Owner = meta.Owner
+	if meta.Export.ExpiresAt.IsZero() {
+		meta.Export.ExpiresAt = meta.Export.CreatedAt.Add(s.retention)
+	}
+	return meta.Export, nil
+}
+
+// Open returns an export of owner together with its data file.
+func (s *ExportStore) Open(owner, id string) (*Export, *os.File, error) {
+	export, err := s.Get(owner, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	file, err := os.Open(s.dataPath(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil, ErrExportNotFound
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open export: %v", err)
+	}
+	return export, file, nil
+}
+
+// Delete removes an export of owner. Downloads that already opened the file
+// can still finish.
+func (s *ExportStore) Delete(owner, id string) error {
+	if _, err := s.Get(owner, id); err != nil {
+		return err
+	}
+	return s.remove(id)
+}
+
+func (s *ExportStore) remove(id string) error {
+	if err := os.Remove(s.metaPath(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete export: %v", err)
+	}
+	if err := os.Remove(s.dataPath(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete export: %v", err)
+	}
+	return nil
+}
+
+func (s *ExportStore) purgeLoop() {
+	interval := min(s.retention/4, time.Hour)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		s.purge(time.Now())
+	}
+}
+
+// purge deletes exports that expired before now, and files of pending
+// exports that have not been written to for the retention period.
+func (s *ExportStore) purge(now time.Time) {
+	exports, err := s.all()
+	if err != nil {
+		log.Printf("Failed to list exports for purging: %v", err)
+		return
+	}
+	for _, export := range exports {
+		if export.ExpiresAt.Before(now) {
+			if err := s.remove(export.ID); err != nil {
+				log.Printf("Failed to purge export %s: %v", export.ID, err)
+			}
+		}
+	}
+
+	parts, err := filepath.Glob(filepath.Join(s.dir, "*.data.part"))
+	if err != nil {
+		return
+	}
+	for _, path := range parts {
+		if info, err := os.Stat(path); err == nil && info.ModTime().Add(s.retention).Before(now) {
+			os.Remove(path)
+		}
+	}
+}
+
+func (s *ExportStore) dataPath(id string) string {
+	return filepath.Join(s.dir, id+".data")
+}
+
+func (s *ExportStore) metaPath(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
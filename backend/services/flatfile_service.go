@@ -2,37 +2,42 @@ package services
 
 import (
 	"fmt"
-    "encoding/csv"
-    "os"
-    "github.com/AditiKulkarni9/clickhouse-flatfile-tool/models"
 )
 
 type FlatFileService struct {
-    filePath  string
-    delimiter string
+	filePath string
+	format   FileFormat
 }
 
-func NewFlatFileService(filePath, delimiter string) *FlatFileService {
-    return &FlatFileService{filePath: filePath, delimiter: delimiter}
+func NewFlatFileService(filePath string, format FileFormat) *FlatFileService {
+	return &FlatFileService{filePath: filePath, format: format}
 }
 
-func (s *FlatFileService) GetColumns() ([]models.Column, error) {
-    file, err := os.Open(s.filePath)
-    if err != nil {
-        return nil, fmt.Errorf("failed to open file: %v", err)
-    }
-    defer file.Close()
+// GetColumns returns the columns of the file with the types inferred from
+// its first sampleRows records. The columns of a Parquet file have the types
+// its schema declares; those of a JSON Lines file are the paths of its keys.
+func (s *FlatFileService) GetColumns(sampleRows int) ([]InferredColumn, error) {
+	switch s.format.Type {
+	case FileTypeParquet:
+		return ParquetColumns(s.filePath)
+	case FileTypeNDJSON:
+		return NDJSONColumns(s.filePath, s.format.Compression, sampleRows)
+	}
+	file, err := openDecompressed(s.filePath, s.format.Compression)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
 
-    reader := csv.NewReader(file)
-    reader.Comma = rune(s.delimiter[0])
-    headers, err := reader.Read()
-    if err != nil {
-        return nil, fmt.Errorf("failed to read headers: %v", err)
-    }
+	reader := NewCSVReader(file, s.format)
+	headers, err := reader.Header()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read headers: %v", err)
+	}
 
-    var columns []models.Column
-    for _, header := range headers {
-        columns = append(columns, models.Column{Name: header, Type: "String"}) // Assume String for simplicity
-    }
-    return columns, nil
-}
\ No newline at end of file
+	columns, err := InferColumns(reader, headers, sampleRows)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sample rows: %v", err)
+	}
+	return columns, nil
+}
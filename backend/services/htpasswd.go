@@ -0,0 +1,180 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HtpasswdAuthenticator checks passwords against an Apache htpasswd file.
+// bcrypt ($2y$), APR1 MD5 ($apr1$) and SHA-1 ({SHA}) entries are supported.
+// The file is re-read whenever it changes.
+type HtpasswdAuthenticator struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	entries map[string]string
+}
+
+// NewHtpasswdAuthenticator loads the htpasswd file at path.
+func NewHtpasswdAuthenticator(path string) (*HtpasswdAuthenticator, error) {
+	a := &HtpasswdAuthenticator{path: path}
+	if err := a.reload(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Authenticate implements Authenticator.
+func (a *HtpasswdAuthenticator) Authenticate(username, password string) error {
+	a.mu.Lock()
+	if err := a.reload(); err != nil {
+		a.mu.Unlock()
+		return err
+	}
+	hash, ok := a.entries[username]
+	a.mu.Unlock()
+	if !ok {
+		return checkBcrypt(nil, password)
+	}
+
+	switch {
+	case strings.HasPrefix(hash, "$2"):
+		return checkBcrypt([]byte(hash), password)
+	case strings.HasPrefix(hash, "$apr1$"):
+		salt, _, _ := strings.Cut(strings.TrimPrefix(hash, "$apr1$"), "$")
+		return compareHash(apr1(password, salt), hash)
+	case strings.HasPrefix(hash, "{SHA}"):
+		sum := sha1.Sum([]byte(password))
+		return compareHash("{SHA}"+base64.StdEncoding.EncodeToString(sum[:]), hash)
+	}
+	return fmt.Errorf("unsupported htpasswd hash for user %q", username)
+}
+
+// UserExists implements Authenticator.
+func (a *HtpasswdAuthenticator) UserExists(username string) (bool, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.reload(); err != nil {
+		return false, err
+	}
+	_, ok := a.entries[username]
+	return ok, nil
+}
+
+func compareHash(computed, stored string) error {
+	if subtle.ConstantTimeCompare([]byte(computed), []byte(stored)) != 1 {
+		return ErrInvalidCredentials
+	}
+	return nil
+}
+
+// reload re-reads the file if its size or modification time changed. The
+// caller must hold the lock, except during construction.
+func (a *HtpasswdAuthenticator) reload() error {
+	info, err := os.Stat(a.path)
+	if err != nil {
+		return fmt.Errorf("failed to read htpasswd file: %v", err)
+	}
+	if a.entries != nil && info.ModTime().Equal(a.modTime) && info.Size() == a.size {
+		return nil
+	}
+
+	data, err := os.ReadFile(a.path)
+	if err != nil {
+		return fmt.Errorf("failed to read htpasswd file: %v", err)
+	}
+	entries := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		user, hash, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		entries[user] = hash
+	}
+	a.entries, a.modTime, a.size = entries, info.ModTime(), info.Size()
+	return nil
+}
+
+const apr1Alphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// apr1 computes Apache's MD5-based crypt variant.
+func apr1(password, salt string) string {
+	const magic = "$apr1$"
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+	pw := []byte(password)
+
+	alt := md5.New()
+	alt.Write(pw)
+	alt.Write([]byte(salt))
+	alt.Write(pw)
+	altSum := alt.Sum(nil)
+
+	ctx := md5.New()
+	ctx.Write(pw)
+	ctx.Write([]byte(magic))
+	ctx.Write([]byte(salt))
+	for i := len(pw); i > 0; i -= 16 {
+		ctx.Write(altSum[:min(i, 16)])
+	}
+	for i := len(pw); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			ctx.Write([]byte{0})
+		} else {
+			ctx.Write(pw[:1])
+		}
+	}
+	sum := ctx.Sum(nil)
+
+	for i := 0; i < 1000; i++ {
+		round := md5.New()
+		if i&1 != 0 {
+			round.Write(pw)
+		} else {
+			round.Write(sum)
+		}
+		if i%3 != 0 {
+			round.Write([]byte(salt))
+		}
+		if i%7 != 0 {
+			round.Write(pw)
+		}
+		if i&1 != 0 {
+			round.Write(sum)
+		} else {
+			round.Write(pw)
+		}
+		sum = round.Sum(nil)
+	}
+
+	var out strings.Builder
+	out.WriteString(magic + salt + "$")
+	encode := func(v uint32, n int) {
+		for ; n > 0; n-- {
+			out.WriteByte(apr1Alphabet[v&0x3f])
+			v >>= 6
+		}
+	}
+	for _, g := range [][3]int{{0, 6, 12}, {1, 7, 13}, {2, 8, 14}, {3, 9, 15}, {4, 10, 5}} {
+		encode(uint32(sum[g[0]])<<16|uint32(sum[g[1]])<<8|uint32(sum[g[2]]), 4)
+	}
+	encode(uint32(sum[11]), 2)
+	return out.String()
+}
@@ -0,0 +1,61 @@
+package services
+
+import "strings"
+
+// DefaultDatabase is used when neither the connection nor the request names one.
+const DefaultDatabase = "default"
+
+// QuoteIdentifier quotes a ClickHouse identifier with backticks.
+func QuoteIdentifier(name string) string {
+	return "`" + strings.NewReplacer(`\`, `\\`, "`", "\\`").Replace(name) + "`"
+}
+
+// QuoteIdentifiers quotes each name and joins them with commas.
+func QuoteIdentifiers(names []string) string {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = QuoteIdentifier(name)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// QuoteString quotes a ClickHouse string literal.
+func QuoteString(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s) + "'"
+}
+
+// QualifiedTable returns the quoted `database`.`table` reference.
+func QualifiedTable(database, table string) string {
+	return QuoteIdentifier(database) + "." + QuoteIdentifier(table)
+}
+
+// SplitTableName splits "database.table" into its parts, falling back to
+// defaultDatabase when name has no database prefix. Backtick-quoted parts
+// may contain dots.
+func SplitTableName(name, defaultDatabase string) (database, table string) {
+	if defaultDatabase == "" {
+		defaultDatabase = DefaultDatabase
+	}
+	name = strings.TrimSpace(name)
+
+	inQuote := false
+	for i := 0; i < len(name); i++ {
+		switch name[i] {
+		case '`':
+			inQuote = !inQuote
+		case '.':
+			if !inQuote {
+				return unquoteIdentifier(name[:i]), unquoteIdentifier(name[i+1:])
+			}
+		}
+	}
+	return defaultDatabase, unquoteIdentifier(name)
+}
+
+func unquoteIdentifier(s string) string {
+	s = strings.TrimSpace(s)
+	if len(s) >= 2 && s[0] == '`' && s[len(s)-1] == '`' {
+		s = strings.NewReplacer("\\`", "`", `\\`, `\`).Replace(s[1 : len(s)-1])
+	}
+	return s
+}
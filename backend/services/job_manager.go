@@ -0,0 +1,428 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// DefaultJobWorkers is the number of jobs that run concurrently.
+	DefaultJobWorkers = 4
+	// DefaultJobQueueSize is the number of jobs that may wait for a worker.
+	DefaultJobQueueSize = 100
+	// DefaultJobRetention is how long finished jobs are kept before they are pruned.
+	DefaultJobRetention = 24 * time.Hour
+	// DefaultMaxFinishedJobs is the number of finished jobs kept; the oldest
+	// are pruned first.
+	DefaultMaxFinishedJobs = 1000
+)
+
+type JobState string
+
+const (
+	JobQueued    JobState = "queued"
+	JobRunning   JobState = "running"
+	JobSucceeded JobState = "succeeded"
+	JobFailed    JobState = "failed"
+	JobCanceled  JobState = "canceled"
+)
+
+var (
+	ErrJobNotFound = errors.New("job not found")
+	ErrJobFinished = errors.New("job already finished")
+	ErrQueueFull   = errors.New("job queue is full")
+)
+
+// JobFunc performs the work of a job. It must stop when ctx is canceled and
+// report progress through the job counters. The returned map is exposed as
+// the job result.
+type JobFunc func(ctx context.Context, job *Job) (map[string]interface{}, error)
+
+// Job is a unit of background work tracked by a JobManager. Only its owner,
+// the session that submitted it, can see or cancel it.
+type Job struct {
+	ID    string
+	Owner string
+	Kind  string
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	run    JobFunc
+	done   chan struct{}
+
+	rows        atomic.Int64
+	bytes       atomic.Int64
+	rowsWritten atomic.Int64
+	rejected    atomic.Int64
+	batch       atomic.Int64
+	totalRows   atomic.Int64
+	totalBytes  atomic.Int64
+
+	mu         sync.Mutex
+	state      JobState
+	err        string
+	errors     []string
+	result     map[string]interface{}
+	createdAt  time.Time
+	startedAt  time.Time
+	finishedAt time.Time
+}
+
+// JobStatus is a point-in-time snapshot of a job.
+type JobStatus struct {
+	ID            string                 `json:"id"`
+	Kind          string                 `json:"kind"`
+	State         JobState               `json:"state"`
+	RowsProcessed int64                  `json:"rowsProcessed"`
+	Bytes         int64                  `json:"bytes"`
+	Error         string                 `json:"error,omitempty"`
+	Errors        []string               `json:"errors"`
+	Result        map[string]interface{} `json:"result,omitempty"`
+	CreatedAt     time.Time              `json:"createdAt"`
+	StartedAt     *time.Time             `json:"startedAt,omitempty"`
+	FinishedAt    *time.Time             `json:"finishedAt,omitempty"`
+	DurationMs    int64                  `json:"durationMs"`
+}
+
+// JobProgress is a lightweight progress report for streaming to clients.
+type JobProgress struct {
+	ID           string   `json:"id"`
+	State        JobState `json:"state"`
+	RowsRead     int64    `json:"rowsRead"`
+	RowsWritten  int64    `json:"rowsWritten"`
+	RejectedRows int64    `json:"rejectedRows"`
+	CurrentBatch int64    `json:"currentBatch"`
+	BytesRead    int64    `json:"bytesRead"`
+	TotalRows    int64    `json:"totalRows,omitempty"`
+	TotalBytes   int64    `json:"totalBytes,omitempty"`
+	Percent      float64  `json:"percent"`
+	ElapsedMs    int64    `json:"elapsedMs"`
+	// ETASeconds is omitted while no estimate is possible.
+	ETASeconds *float64 `json:"etaSeconds,omitempty"`
+}
+
+// AddRows records n more processed rows.
+func (j *Job) AddRows(n int64) { j.rows.Add(n) }
+
+// AddBytes records n more processed bytes.
+func (j *Job) AddBytes(n int64) { j.bytes.Add(n) }
+
+// SetRowsWritten records the number of rows delivered to the target so far.
+func (j *Job) SetRowsWritten(n int64) { j.rowsWritten.Store(n) }
+
+// AddRejected records n more rejected rows.
+func (j *Job) AddRejected(n int64) { j.rejected.Add(n) }
+
+// SetBatch records the number of the batch currently being filled.
+func (j *Job) SetBatch(n int64) { j.batch.Store(n) }
+
+// SetTotalRows sets the expected number of rows, used to estimate completion.
+func (j *Job) SetTotalRows(n int64) { j.totalRows.Store(n) }
+
+// SetTotalBytes sets the expected number of bytes, used to estimate completion.
+// It takes precedence over the row total.
+func (j *Job) SetTotalBytes(n int64) { j.totalBytes.Store(n) }
+
+// Progress returns the current counters together with a completion estimate.
+func (j *Job) Progress() JobProgress {
+	j.mu.Lock()
+	state, started, finished := j.state, j.startedAt, j.finishedAt
+	j.mu.Unlock()
+
+	p := JobProgress{
+		ID:           j.ID,
+		State:        state,
+		RowsRead:     j.rows.Load(),
+		RowsWritten:  j.rowsWritten.Load(),
+		RejectedRows: j.rejected.Load(),
+		CurrentBatch: j.batch.Load(),
+		BytesRead:    j.bytes.Load(),
+		TotalRows:    j.totalRows.Load(),
+		TotalBytes:   j.totalBytes.Load(),
+	}
+	if started.IsZero() {
+		return p
+	}
+	end := time.Now()
+	if !finished.IsZero() {
+		end = finished
+	}
+	elapsed := end.Sub(started)
+	p.ElapsedMs = elapsed.Milliseconds()
+
+	var done, total int64
+	switch {
+	case p.TotalBytes > 0:
+		done, total = p.BytesRead, p.TotalBytes
+	case p.TotalRows > 0:
+		done, total = p.RowsRead, p.TotalRows
+	}
+	if state == JobSucceeded {
+		p.Percent = 100
+	} else if total > 0 {
+		p.Percent = min(100, float64(done)*100/float64(total))
+	}
+	if state == JobRunning && done > 0 && total > done {
+		eta := elapsed.Seconds() * float64(total-done) / float64(done)
+		p.ETASeconds = &eta
+	}
+	return p
+}
+
+// AddError records a non-fatal error.
+func (j *Job) AddError(msg string) {
+	j.mu.Lock()
+	j.errors = append(j.errors, msg)
+	j.mu.Unlock()
+}
+
+// Done is closed once the job reaches a final state.
+func (j *Job) Done() <-chan struct{} { return j.done }
+
+// Status returns a snapshot of the job.
+func (j *Job) Status() JobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	status := JobStatus{
+		ID:            j.ID,
+		Kind:          j.Kind,
+		State:         j.state,
+		RowsProcessed: j.rows.Load(),
+		Bytes:         j.bytes.Load(),
+		Error:         j.err,
+		Errors:        append([]string{}, j.errors...),
+		Result:        j.result,
+		CreatedAt:     j.createdAt,
+	}
+	if !j.startedAt.IsZero() {
+		started := j.startedAt
+		status.StartedAt = &started
+		end := time.Now()
+		if !j.finishedAt.IsZero() {
+			finished := j.finishedAt
+			status.FinishedAt = &finished
+			end = finished
+		}
+		status.DurationMs = end.Sub(started).Milliseconds()
+	}
+	return status
+}
+
+// ended returns when the job finished, and false if it has not.
+func (j *Job) ended() (time.Time, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.finishedAt, j.finished()
+}
+
+func (j *Job) finished() bool {
+	return j.state == JobSucceeded || j.state == JobFailed || j.state == JobCanceled
+}
+
+func (j *Job) finish(state JobState, result map[string]interface{}, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.finished() {
+		return
+	}
+	j.state = state
+	j.result = result
+	if err != nil {
+		j.err = err.Error()
+	}
+	j.finishedAt = time.Now()
+	close(j.done)
+}
+
+// JobManager keeps a registry of jobs and runs them on a fixed pool of workers.
+// Finished jobs are pruned once they are older than the retention period or
+// more than maxFinished of them are kept.
+type JobManager struct {
+	mu          sync.RWMutex
+	jobs        map[string]*Job
+	order       []string
+	queue       chan *Job
+	retention   time.Duration
+	maxFinished int
+}
+
+// NewJobManager starts workers goroutines that consume up to queueSize pending
+// jobs, and starts pruning finished jobs.
+func NewJobManager(workers, queueSize int, retention time.Duration, maxFinished int) *JobManager {
+	if workers <= 0 {
+		workers = DefaultJobWorkers
+	}
+	if queueSize <= 0 {
+		queueSize = DefaultJobQueueSize
+	}
+	if retention <= 0 {
+		retention = DefaultJobRetention
+	}
+	if maxFinished <= 0 {
+		maxFinished = DefaultMaxFinishedJobs
+	}
+	m := &JobManager{
+		jobs:        make(map[string]*Job),
+		queue:       make(chan *Job, queueSize),
+		retention:   retention,
+		maxFinished: maxFinished,
+	}
+	for i := 0; i < workers; i++ {
+		go m.worker()
+	}
+	go m.pruneLoop()
+	return m
+}
+
+// Submit registers a job of owner and queues it for execution.
+func (m *JobManager) Submit(owner, kind string, fn JobFunc) (*Job, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &Job{
+		ID:        uuid.NewString(),
+		Owner:     owner,
+		Kind:      kind,
+		ctx:       ctx,
+		cancel:    cancel,
+		run:       fn,
+		done:      make(chan struct{}),
+		state:     JobQueued,
+		createdAt: time.Now(),
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	select {
+	case m.queue <- job:
+	default:
+		cancel()
+		return nil, ErrQueueFull
+	}
+	m.jobs[job.ID] = job
+	m.order = append(m.order, job.ID)
+	m.prune(time.Now())
+	return job, nil
+}
+
+// Get returns the job of owner with the given ID. Jobs of other owners are
+// not found.
+func (m *JobManager) Get(owner, id string) (*Job, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	job, ok := m.jobs[id]
+	if !ok || job.Owner != owner {
+		return nil, ErrJobNotFound
+	}
+	return job, nil
+}
+
+// List returns snapshots of the jobs of owner in submission order.
+func (m *JobManager) List(owner string) []JobStatus {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	statuses := make([]JobStatus, 0)
+	for _, id := range m.order {
+		if job := m.jobs[id]; job.Owner == owner {
+			statuses = append(statuses, job.Status())
+		}
+	}
+	return statuses
+}
+
+// Cancel stops a queued or running job of owner.
+func (m *JobManager) Cancel(owner, id string) error {
+	job, err := m.Get(owner, id)
+	if err != nil {
+		return err
+	}
+	job.mu.Lock()
+	if job.finished() {
+		job.mu.Unlock()
+		return ErrJobFinished
+	}
+	queued := job.state == JobQueued
+	job.mu.Unlock()
+
+	job.cancel()
+	if queued {
+		job.finish(JobCanceled, nil, context.Canceled)
+	}
+	return nil
+}
+
+func (m *JobManager) pruneLoop() {
+	ticker := time.NewTicker(min(m.retention/4, time.Hour))
+	defer ticker.Stop()
+	for range ticker.C {
+		m.mu.Lock()
+		m.prune(time.Now())
+		m.mu.Unlock()
+	}
+}
+
+// prune forgets finished jobs that finished before the retention period, and
+// the oldest finished jobs beyond maxFinished. m.mu must be held.
+func (m *JobManager) prune(now time.Time) {
+	finished := 0
+	for _, id := range m.order {
+		if _, ok := m.jobs[id].ended(); ok {
+			finished++
+		}
+	}
+	order := m.order[:0]
+	for _, id := range m.order {
+		at, ok := m.jobs[id].ended()
+		if ok && (finished > m.maxFinished || now.Sub(at) > m.retention) {
+			delete(m.jobs, id)
+			finished--
+			continue
+		}
+		order = append(order, id)
+	}
+	clear(m.order[len(order):])
+	m.order = order
+}
+
+func (m *JobManager) worker() {
+	for job := range m.queue {
+		m.execute(job)
+	}
+}
+
+func (m *JobManager) execute(job *Job) {
+	job.mu.Lock()
+	if job.finished() {
+		job.mu.Unlock()
+		return
+	}
+	job.state = JobRunning
+	job.startedAt = time.Now()
+	job.mu.Unlock()
+	defer job.cancel()
+
+	result, err := runJob(job)
+	switch {
+	case job.ctx.Err() != nil:
+		job.finish(JobCanceled, result, context.Canceled)
+	case err != nil:
+		job.finish(JobFailed, result, err)
+	default:
+		job.finish(JobSucceeded, result, nil)
+	}
+}
+
+// runJob shields the worker from panics in job code.
+func runJob(job *Job) (result map[string]interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+	return job.run(job.ctx, job)
+}
@@ -0,0 +1,169 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestJobProgress(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name              string
+		state             JobState
+		started, finished time.Time
+		rows, bytes       int64
+		totalRows         int64
+		totalBytes        int64
+		percent           float64
+		eta               float64 // negative for no estimate
+	}{
+		{name: "queued", state: JobQueued, totalRows: 100, eta: -1},
+		{name: "rows", state: JobRunning, started: now.Add(-10 * time.Second), rows: 25, totalRows: 100, percent: 25, eta: 30},
+		{name: "bytes", state: JobRunning, started: now.Add(-10 * time.Second), bytes: 50, totalBytes: 100, percent: 50, eta: 10},
+		{name: "bytes before rows", state: JobRunning, started: now.Add(-10 * time.Second), rows: 90, totalRows: 100, bytes: 20, totalBytes: 100, percent: 20, eta: 40},
+		{name: "no total", state: JobRunning, started: now.Add(-10 * time.Second), rows: 25, eta: -1},
+		{name: "nothing done yet", state: JobRunning, started: now.Add(-10 * time.Second), totalRows: 100, eta: -1},
+		{name: "past the total", state: JobRunning, started: now.Add(-10 * time.Second), rows: 150, totalRows: 100, percent: 100, eta: -1},
+		{name: "succeeded", state: JobSucceeded, started: now.Add(-10 * time.Second), finished: now.Add(-5 * time.Second), rows: 80, totalRows: 100, percent: 100, eta: -1},
+		{name: "failed", state: JobFailed, started: now.Add(-10 * time.Second), finished: now, rows: 40, totalRows: 100, percent: 40, eta: -1},
+	}
+	for _, tt := range tests {
+		job := &Job{ID: "job", state: tt.state, startedAt: tt.started, finishedAt: tt.finished}
+		job.AddRows(tt.rows)
+		job.AddBytes(tt.bytes)
+		job.SetTotalRows(tt.totalRows)
+		job.SetTotalBytes(tt.totalBytes)
+
+		p := job.Progress()
+		if p.State != tt.state || p.RowsRead != tt.rows || p.BytesRead != tt.bytes {
+			t.Errorf("%s: counters %+v", tt.name, p)
+		}
+		if p.Percent != tt.percent {
+			t.Errorf("%s: percent = %v, want %v", tt.name, p.Percent, tt.percent)
+		}
+		switch {
+		case tt.eta < 0 && p.ETASeconds != nil:
+			t.Errorf("%s: eta = %v, want none", tt.name, *p.ETASeconds)
+		case tt.eta >= 0 && (p.ETASeconds == nil || math.Abs(*p.ETASeconds-tt.eta) > 1):
+			t.Errorf("%s: eta = %v, want about %v", tt.name, p.ETASeconds, tt.eta)
+		}
+		if tt.state == JobSucceeded && p.ElapsedMs != 5000 {
+			t.Errorf("%s: elapsed = %dms, want 5000ms", tt.name, p.ElapsedMs)
+		}
+	}
+}
+
+// blockingJob runs until it is canceled.
+func blockingJob(started chan<- struct{}) JobFunc {
+	return func(ctx context.Context, job *Job) (map[string]interface{}, error) {
+		if started != nil {
+			close(started)
+		}
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+}
+
+func TestJobManagerCancel(t *testing.T) {
+	m := NewJobManager(1, 10, 0, 0)
+	started := make(chan struct{})
+	running, err := m.Submit("alice", "test", blockingJob(started))
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	queued, err := m.Submit("alice", "test", blockingJob(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		owner string
+		job   *Job
+		err   error
+	}{
+		{"other owner", "bob", running, ErrJobNotFound},
+		{"unknown job", "alice", &Job{ID: "missing"}, ErrJobNotFound},
+		{"queued", "alice", queued, nil},
+		{"running", "alice", running, nil},
+		{"already canceled", "alice", running, ErrJobFinished},
+	}
+	for _, tt := range tests {
+		if err := m.Cancel(tt.owner, tt.job.ID); !errors.Is(err, tt.err) {
+			t.Errorf("%s: Cancel = %v, want %v", tt.name, err, tt.err)
+		}
+		if tt.err == nil {
+			select {
+			case <-tt.job.Done():
+			case <-time.After(5 * time.Second):
+				t.Fatalf("%s: job did not stop", tt.name)
+			}
+			if state := tt.job.Status().State; state != JobCanceled {
+				t.Errorf("%s: state = %s, want %s", tt.name, state, JobCanceled)
+			}
+		}
+	}
+	if jobs := m.List("bob"); len(jobs) != 0 {
+		t.Errorf("List(bob) = %v, want none", jobs)
+	}
+	if jobs := m.List("alice"); len(jobs) != 2 {
+		t.Errorf("List(alice) has %d jobs, want 2", len(jobs))
+	}
+}
+
+func TestJobManagerPrune(t *testing.T) {
+	m := NewJobManager(1, 10, time.Hour, 2)
+	var jobs []*Job
+	for i := 0; i < 3; i++ {
+		job, err := m.Submit("alice", "test", func(ctx context.Context, job *Job) (map[string]interface{}, error) {
+			return nil, nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		<-job.Done()
+		jobs = append(jobs, job)
+	}
+	started := make(chan struct{})
+	running, err := m.Submit("alice", "test", blockingJob(started))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Cancel("alice", running.ID)
+	<-started
+
+	tests := []struct {
+		name string
+		now  time.Time
+		kept []*Job
+	}{
+		{"beyond the cap", time.Now(), []*Job{jobs[1], jobs[2], running}},
+		{"past the retention", time.Now().Add(2 * time.Hour), []*Job{running}},
+	}
+	for _, tt := range tests {
+		m.mu.Lock()
+		m.prune(tt.now)
+		m.mu.Unlock()
+
+		kept := make(map[string]bool)
+		for _, job := range tt.kept {
+			kept[job.ID] = true
+		}
+		for _, job := range append(jobs, running) {
+			_, err := m.Get("alice", job.ID)
+			if kept[job.ID] && err != nil {
+				t.Errorf("%s: Get(%s) = %v, want the job", tt.name, job.ID, err)
+			}
+			if !kept[job.ID] && !errors.Is(err, ErrJobNotFound) {
+				t.Errorf("%s: Get(%s) = %v, want %v", tt.name, job.ID, err, ErrJobNotFound)
+			}
+		}
+		if got := len(m.List("alice")); got != len(tt.kept) {
+			t.Errorf("%s: List has %d jobs, want %d", tt.name, got, len(tt.kept))
+		}
+	}
+}
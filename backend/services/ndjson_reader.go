@@ -0,0 +1,290 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// isNDJSON reports whether a sample starts with a JSON object on a line of
+// its own. A first line longer than the sample is given the benefit of the
+// doubt.
+func isNDJSON(sample []byte) bool {
+	sample = bytes.TrimLeft(bytes.TrimPrefix(sample, utf8BOM), " \t\r\n")
+	if len(sample) == 0 || sample[0] != '{' {
+		return false
+	}
+	line, _, found := bytes.Cut(sample, []byte("\n"))
+	if !found && len(sample) >= uploadSniffBytes-len(utf8BOM) {
+		return true
+	}
+	_, err := decodeObject(line)
+	return err == nil
+}
+
+func decodeObject(data []byte) (map[string]interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var obj map[string]interface{}
+	if err := dec.Decode(&obj); err != nil {
+		return nil, err
+	}
+	if obj == nil {
+		return nil, errors.New("expected a JSON object, got null")
+	}
+	if dec.More() {
+		return nil, errors.New("unexpected data after the JSON object")
+	}
+	return obj, nil
+}
+
+// ndjsonRecords reads a JSON Lines file: one JSON object per line. The
+// columns are paths into the objects; a path names a key, or a key of a
+// nested object after a dot, such as user.id. Missing keys read as nil.
+type ndjsonRecords struct {
+	path        string
+	compression string
+	file        io.ReadCloser
+	r           *bufio.Reader
+	columns     []string
+	line        int // line of the last record read
+	next        int // line the next record starts on
+}
+
+// openNDJSONRecords opens a JSON Lines file. Without columns, the header
+// lists every path found in the first DefaultSampleRows objects.
+func openNDJSONRecords(path, compression string, columns []string) (*ndjsonRecords, error) {
+	file, err := openDecompressed(path, compression)
+	if err != nil {
+		return nil, err
+	}
+	r := bufio.NewReaderSize(file, 64<<10)
+	if bom, err := r.Peek(len(utf8BOM)); err == nil && bytes.Equal(bom, utf8BOM) {
+		r.Discard(len(utf8BOM))
+	}
+	return &ndjsonRecords{path: path, compression: compression, file: file, r: r, columns: columns, next: 1}, nil
+}
+
+func (r *ndjsonRecords) Header() ([]string, error) {
+	if r.columns == nil {
+		columns, err := NDJSONColumns(r.path, r.compression, DefaultSampleRows)
+		if err != nil {
+			return nil, err
+		}
+		r.columns = make([]string, len(columns))
+		for i, col := range columns {
+			r.columns[i] = col.Name
+		}
+	}
+	return r.columns, nil
+}
+
+// Read returns the values of the columns in the next object. A line that
+// is not a JSON object is returned as a single field together with
+// ErrMalformedRecord.
+func (r *ndjsonRecords) Read() ([]interface{}, error) {
+	obj, line, err := r.readObject()
+	if err != nil {
+		if errors.Is(err, ErrMalformedRecord) {
+			return []interface{}{string(line)}, err
+		}
+		return nil, err
+	}
+	record := make([]interface{}, len(r.columns))
+	for i, col := range r.columns {
+		record[i], _ = lookupPath(obj, col)
+	}
+	return record, nil
+}
+
+// readObject returns the next non-empty line and the object it holds.
+func (r *ndjsonRecords) readObject() (map[string]interface{}, []byte, error) {
+	for {
+		data, err := r.r.ReadBytes('\n')
+		if err != nil && (err != io.EOF || len(data) == 0) {
+			return nil, nil, err
+		}
+		r.line = r.next
+		r.next++
+		data = bytes.TrimSpace(data)
+		if len(data) == 0 {
+			continue
+		}
+		obj, err := decodeObject(data)
+		if err != nil {
+			return nil, data, fmt.Errorf("line %d: %w: %v", r.line, ErrMalformedRecord, err)
+		}
+		return obj, data, nil
+	}
+}
+
+func (r *ndjsonRecords) Line() int { return r.line }
+
+func (r *ndjsonRecords) TotalRows() int64 { return 0 }
+
+func (r *ndjsonRecords) Close() error { return r.file.Close() }
+
+// lookupPath returns the value at path in obj: the key path itself if obj
+// has one, otherwise a key of a nested object reached by splitting path at
+// a dot.
+func lookupPath(obj map[string]interface{}, path string) (interface{}, bool) {
+	if v, ok := obj[path]; ok {
+		return v, true
+	}
+	for i := 0; i < len(path); i++ {
+		if path[i] != '.' {
+			continue
+		}
+		if inner, ok := obj[path[:i]].(map[string]interface{}); ok {
+			if v, ok := lookupPath(inner, path[i+1:]); ok {
+				return v, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// flattenObject calls visit for every value of obj that is not itself an
+// object, with its dotted path. Empty objects are left out.
+func flattenObject(prefix string, obj map[string]interface{}, order []string, visit func(path string, v interface{})) {
+	for _, key := range order {
+		v := obj[key]
+		if inner, ok := v.(map[string]interface{}); ok {
+			flattenObject(prefix+key+".", inner, objectKeys(inner), visit)
+			continue
+		}
+		visit(prefix+key, v)
+	}
+}
+
+// jsonFieldStats accumulates the values seen at one path of a JSON Lines
+// file. Arrays are typed by their elements.
+type jsonFieldStats struct {
+	scalars  *columnStats
+	elements *columnStats
+	arrays   int
+	present  int
+	examples []string
+}
+
+func (f *jsonFieldStats) observe(v interface{}) {
+	f.present++
+	items, isArray := v.([]interface{})
+	if !isArray {
+		f.scalars.observe(valueText(v))
+		return
+	}
+	f.arrays++
+	if len(f.examples) < maxExamples {
+		f.examples = append(f.examples, valueText(v))
+	}
+	for _, item := range items {
+		f.elements.observe(valueText(item))
+	}
+}
+
+// infer proposes a type for the field. Fields missing from some of the rows
+// sampled are Nullable. Arrays cannot be, and fields holding both arrays and
+// other values are kept as JSON text.
+func (f *jsonFieldStats) infer(name string, complete bool, rows int) InferredColumn {
+	f.scalars.nulls += rows - f.present
+	switch {
+	case f.arrays == 0:
+		return f.scalars.infer(name, complete)
+	case f.arrays == f.present:
+		column := f.elements.infer(name, complete)
+		column.Type = "Array(" + column.Type + ")"
+		column.Examples = f.examples
+		return column
+	}
+	column := f.scalars.infer(name, complete)
+	column.Type = "String"
+	if f.scalars.nulls > 0 {
+		column.Type = "Nullable(String)"
+	}
+	column.Examples = append(f.examples, column.Examples...)
+	return column
+}
+
+// NDJSONColumns lists the fields of a JSON Lines file, with the keys of
+// nested objects as dotted paths such as user.id, and proposes a ClickHouse
+// type for each from the first sampleRows objects. Lines that are not JSON
+// objects are left out of the sample.
+func NDJSONColumns(path, compression string, sampleRows int) ([]InferredColumn, error) {
+	r, err := openNDJSONRecords(path, compression, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var names []string
+	fields := make(map[string]*jsonFieldStats)
+	complete, rows := false, 0
+	for rows < sampleRows {
+		obj, line, err := r.readObject()
+		if err == io.EOF {
+			complete = true
+			break
+		}
+		if errors.Is(err, ErrMalformedRecord) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read line %d: %v", r.next, err)
+		}
+		rows++
+		flattenObject("", obj, keyOrder(line, obj), func(path string, v interface{}) {
+			f, ok := fields[path]
+			if !ok {
+				f = &jsonFieldStats{scalars: newColumnStats(), elements: newColumnStats()}
+				fields[path] = f
+				names = append(names, path)
+			}
+			f.observe(v)
+		})
+	}
+
+	columns := make([]InferredColumn, len(names))
+	for i, name := range names {
+		columns[i] = fields[name].infer(name, complete, rows)
+	}
+	return columns, nil
+}
+
+// keyOrder returns the top-level keys of obj in the order they appear in
+// data, which encoding/json does not keep.
+func keyOrder(data []byte, obj map[string]interface{}) []string {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	keys := make([]string, 0, len(obj))
+	if _, err := dec.Token(); err != nil {
+		return objectKeys(obj)
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return objectKeys(obj)
+		}
+		keys = append(keys, tok.(string))
+		var skip json.RawMessage
+		if err := dec.Decode(&skip); err != nil {
+			return objectKeys(obj)
+		}
+	}
+	return keys
+}
+
+// objectKeys returns the keys of obj in sorted order.
+func objectKeys(obj map[string]interface{}) []string {
+	keys := make([]string, 0, len(obj))
+	for key := range obj {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,544 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/format"
+	"github.com/shopspring/decimal"
+)
+
+// parquetMagic starts and ends every Parquet file.
+const parquetMagic = "PAR1"
+
+// parquetReadRows is how many rows are read from a row group at a time.
+const parquetReadRows = 1024
+
+// julianUnixEpoch is the Julian day number of 1970-01-01, used by INT96
+// timestamps.
+const julianUnixEpoch = 2440588
+
+// ParquetColumns lists the top-level columns of a Parquet file with the
+// ClickHouse types their Parquet types map to, and a few example values.
+func ParquetColumns(path string) ([]InferredColumn, error) {
+	records, err := openParquetRecords(path, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer records.Close()
+
+	columns := make([]InferredColumn, len(records.fields))
+	for i, f := range records.fields {
+		columns[i].Name = f.name
+		columns[i].Type = f.clickHouseType(true)
+		columns[i].Confidence = 1
+		columns[i].Examples = []string{}
+	}
+	for n := 0; n < maxExamples; n++ {
+		record, err := records.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		for i, text := range RecordText(record) {
+			columns[i].Examples = append(columns[i].Examples, text)
+		}
+	}
+	return columns, nil
+}
+
+// parquetRecords reads the rows of a Parquet file as records of JSON-like
+// values: nil, bool, json.Number, string, []interface{} for lists and
+// map[string]interface{} for maps and structs. Times are RFC 3339 strings.
+type parquetRecords struct {
+	file      *os.File
+	pf        *parquet.File
+	fields    []*parquetColumn
+	conv      parquet.Conversion
+	rowGroups []parquet.RowGroup
+	rows      parquet.Rows
+	buf       []parquet.Row
+	pending   []parquet.Row
+	line      int
+
+	columns [][]parquet.Value
+	pos     []int
+}
+
+// openParquetRecords opens a Parquet file. If columns is set, only those of
+// its top-level columns are read, in that order; names the file does not
+// have are left out of the header for the caller to report.
+func openParquetRecords(path string, columns []string) (*parquetRecords, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %v", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to open file: %v", err)
+	}
+	pf, err := parquet.OpenFile(file, info.Size())
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to read Parquet file: %v", err)
+	}
+
+	all := pf.Schema().Fields()
+	selected := all
+	if columns != nil {
+		byName := make(map[string]parquet.Field, len(all))
+		for _, f := range all {
+			byName[f.Name()] = f
+		}
+		selected = nil
+		for _, name := range columns {
+			if f, ok := byName[name]; ok {
+				selected = append(selected, f)
+				delete(byName, name)
+			}
+		}
+	}
+
+	r := &parquetRecords{file: file, pf: pf, rowGroups: pf.RowGroups()}
+	group := make(parquetGroup, len(selected))
+	leaf := 0
+	for i, f := range selected {
+		group[i] = parquetField{Node: f, name: f.Name()}
+		col := newParquetColumn(f.Name(), f, &leaf, 0, 0)
+		r.fields = append(r.fields, col)
+	}
+	r.conv, err = parquet.Convert(parquet.NewSchema(pf.Schema().Name(), group), pf.Schema())
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to read Parquet columns: %v", err)
+	}
+	r.columns = make([][]parquet.Value, leaf)
+	r.pos = make([]int, leaf)
+	return r, nil
+}
+
+func (r *parquetRecords) Header() ([]string, error) {
+	names := make([]string, len(r.fields))
+	for i, f := range r.fields {
+		names[i] = f.name
+	}
+	return names, nil
+}
+
+func (r *parquetRecords) Read() ([]interface{}, error) {
+	for len(r.pending) == 0 {
+		if r.rows == nil {
+			if len(r.rowGroups) == 0 {
+				return nil, io.EOF
+			}
+			r.rows = parquet.ConvertRowGroup(r.rowGroups[0], r.conv).Rows()
+			r.rowGroups = r.rowGroups[1:]
+		}
+		if r.buf == nil {
+			r.buf = make([]parquet.Row, parquetReadRows)
+		}
+		n, err := r.rows.ReadRows(r.buf)
+		r.pending = r.buf[:n]
+		if err == io.EOF {
+			r.rows.Close()
+			r.rows = nil
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to read Parquet row %d: %v", r.line+n+1, err)
+		}
+	}
+	row := r.pending[0]
+	r.pending = r.pending[1:]
+	r.line++
+
+	for i := range r.columns {
+		r.columns[i] = r.columns[i][:0]
+		r.pos[i] = 0
+	}
+	for _, v := range row {
+		if c := v.Column(); c >= 0 && c < len(r.columns) {
+			r.columns[c] = append(r.columns[c], v)
+		}
+	}
+	record := make([]interface{}, len(r.fields))
+	for i, f := range r.fields {
+		v, err := r.read(f)
+		if err != nil {
+			return nil, fmt.Errorf("row %d: column %s: %v", r.line, f.name, err)
+		}
+		record[i] = v
+	}
+	return record, nil
+}
+
+// Line returns the number of the last row read, starting at 1.
+func (r *parquetRecords) Line() int { return r.line }
+
+func (r *parquetRecords) TotalRows() int64 { return r.pf.NumRows() }
+
+func (r *parquetRecords) Close() error {
+	if r.rows != nil {
+		r.rows.Close()
+	}
+	return r.file.Close()
+}
+
+// parquetColumn is a node of the schema being read, with the levels needed
+// to assemble its values from the leaf columns below it.
+type parquetColumn struct {
+	name     string
+	node     parquet.Node
+	column   int // first leaf column
+	leaves   int
+	def      int // definition level at which the node is present
+	depth    int // repetition depth, counting the node itself if repeated
+	optional bool
+	repeated bool
+	kind     parquetKind
+	children []*parquetColumn
+}
+
+type parquetKind int
+
+const (
+	parquetLeaf   parquetKind = iota
+	parquetList               // LIST group: one repeated child
+	parquetMap                // MAP group: one repeated key/value child
+	parquetStruct             // any other group
+	parquetSingle             // repeated group of a list holding just the element
+	parquetPair               // repeated group of a map holding key and value
+)
+
+func newParquetColumn(name string, node parquet.Node, leaf *int, def, depth int) *parquetColumn {
+	c := &parquetColumn{name: name, node: node, column: *leaf, optional: node.Optional(), repeated: node.Repeated()}
+	if c.optional || c.repeated {
+		def++
+	}
+	if c.repeated {
+		depth++
+	}
+	c.def, c.depth = def, depth
+
+	if node.Leaf() {
+		c.kind = parquetLeaf
+		*leaf++
+		c.leaves = 1
+		return c
+	}
+	for _, f := range node.Fields() {
+		c.children = append(c.children, newParquetColumn(f.Name(), f, leaf, def, depth))
+	}
+	c.leaves = *leaf - c.column
+
+	c.kind = parquetStruct
+	lt := node.Type().LogicalType()
+	if len(c.children) == 1 && c.children[0].repeated {
+		child := c.children[0]
+		switch {
+		case lt != nil && lt.Map != nil, len(child.children) == 2 && child.children[0].name == "key":
+			if len(child.children) == 2 {
+				c.kind, child.kind = parquetMap, parquetPair
+			}
+		case lt != nil && lt.List != nil:
+			c.kind = parquetList
+			if child.kind == parquetStruct && len(child.children) == 1 {
+				child.kind = parquetSingle
+			}
+		}
+	}
+	return c
+}
+
+// clickHouseType returns the ClickHouse type for the column. Composite
+// types cannot be Nullable, so an optional list, map or struct is not.
+func (c *parquetColumn) clickHouseType(top bool) string {
+	var typ string
+	switch {
+	case c.repeated && top:
+		// A repeated top-level field is a list of its values.
+		inner := *c
+		inner.repeated = false
+		return "Array(" + inner.clickHouseType(false) + ")"
+	case c.kind == parquetLeaf:
+		typ = parquetLeafType(c.node.Type())
+	case c.kind == parquetList:
+		return "Array(" + c.children[0].elementType() + ")"
+	case c.kind == parquetMap:
+		pair := c.children[0]
+		key := pair.children[0].clickHouseType(false)
+		key = strings.TrimSuffix(strings.TrimPrefix(key, "Nullable("), ")")
+		return "Map(" + key + ", " + pair.children[1].clickHouseType(false) + ")"
+	default:
+		fields := make([]string, len(c.children))
+		for i, child := range c.children {
+			fields[i] = child.name + " " + child.clickHouseType(false)
+		}
+		return "Tuple(" + strings.Join(fields, ", ") + ")"
+	}
+	if c.optional {
+		typ = "Nullable(" + typ + ")"
+	}
+	return typ
+}
+
+// elementType is the ClickHouse type of the elements of a list whose
+// repeated group is c.
+func (c *parquetColumn) elementType() string {
+	if c.kind == parquetSingle {
+		return c.children[0].clickHouseType(false)
+	}
+	inner := *c
+	inner.repeated = false
+	return inner.clickHouseType(false)
+}
+
+func parquetLeafType(t parquet.Type) string {
+	if lt := t.LogicalType(); lt != nil {
+		switch {
+		case lt.UTF8 != nil, lt.Enum != nil, lt.Json != nil, lt.Bson != nil, lt.Time != nil:
+			return "String"
+		case lt.UUID != nil:
+			return "UUID"
+		case lt.Decimal != nil:
+			return fmt.Sprintf("Decimal(%d, %d)", lt.Decimal.Precision, lt.Decimal.Scale)
+		case lt.Date != nil:
+			return "Date32"
+		case lt.Timestamp != nil:
+			precision := 3
+			switch {
+			case lt.Timestamp.Unit.Micros != nil:
+				precision = 6
+			case lt.Timestamp.Unit.Nanos != nil:
+				precision = 9
+			}
+			if lt.Timestamp.IsAdjustedToUTC {
+				return fmt.Sprintf("DateTime64(%d, 'UTC')", precision)
+			}
+			return fmt.Sprintf("DateTime64(%d)", precision)
+		case lt.Integer != nil:
+			if lt.Integer.IsSigned {
+				return fmt.Sprintf("Int%d", lt.Integer.BitWidth)
+			}
+			return fmt.Sprintf("UInt%d", lt.Integer.BitWidth)
+		}
+	}
+	switch t.Kind() {
+	case parquet.Boolean:
+		return "Bool"
+	case parquet.Int32:
+		return "Int32"
+	case parquet.Int64:
+		return "Int64"
+	case parquet.Int96:
+		return "DateTime64(9, 'UTC')"
+	case parquet.Float:
+		return "Float32"
+	case parquet.Double:
+		return "Float64"
+	case parquet.FixedLenByteArray:
+		return fmt.Sprintf("FixedString(%d)", t.Length())
+	}
+	return "String"
+}
+
+// read assembles the value of c from the leaf values at the current
+// positions.
+func (r *parquetRecords) read(c *parquetColumn) (interface{}, error) {
+	first, ok := r.peek(c.column)
+	if !ok {
+		return nil, fmt.Errorf("missing values")
+	}
+	if c.repeated {
+		items := []interface{}{}
+		if first.DefinitionLevel() < c.def {
+			r.skip(c)
+			return items, nil
+		}
+		for {
+			item, err := r.readPresent(c)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+			next, ok := r.peek(c.column)
+			if !ok || next.RepetitionLevel() < c.depth {
+				return items, nil
+			}
+		}
+	}
+	if c.optional && first.DefinitionLevel() < c.def {
+		r.skip(c)
+		return nil, nil
+	}
+	return r.readPresent(c)
+}
+
+// readPresent reads c once it is known to be present.
+func (r *parquetRecords) readPresent(c *parquetColumn) (interface{}, error) {
+	if c.kind == parquetLeaf {
+		v := r.columns[c.column][r.pos[c.column]]
+		r.pos[c.column]++
+		return parquetLeafValue(c.node.Type(), v)
+	}
+	values := make([]interface{}, len(c.children))
+	for i, child := range c.children {
+		v, err := r.read(child)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+	switch c.kind {
+	case parquetList, parquetSingle:
+		return values[0], nil
+	case parquetPair:
+		return values, nil
+	case parquetMap:
+		pairs, _ := values[0].([]interface{})
+		m := make(map[string]interface{}, len(pairs))
+		for _, p := range pairs {
+			pair := p.([]interface{})
+			m[valueText(pair[0])] = pair[1]
+		}
+		return m, nil
+	}
+	m := make(map[string]interface{}, len(c.children))
+	for i, child := range c.children {
+		m[child.name] = values[i]
+	}
+	return m, nil
+}
+
+func (r *parquetRecords) peek(column int) (parquet.Value, bool) {
+	if r.pos[column] >= len(r.columns[column]) {
+		return parquet.Value{}, false
+	}
+	return r.columns[column][r.pos[column]], true
+}
+
+// skip consumes the single value every leaf below a missing node has.
+func (r *parquetRecords) skip(c *parquetColumn) {
+	for i := c.column; i < c.column+c.leaves; i++ {
+		r.pos[i]++
+	}
+}
+
+// parquetLeafValue converts a Parquet value to a JSON-like value according
+// to the logical type of its column.
+func parquetLeafValue(t parquet.Type, v parquet.Value) (interface{}, error) {
+	if v.IsNull() {
+		return nil, nil
+	}
+	lt := t.LogicalType()
+	if lt == nil {
+		lt = &format.LogicalType{}
+	}
+	switch v.Kind() {
+	case parquet.Boolean:
+		return v.Boolean(), nil
+	case parquet.Int32, parquet.Int64:
+		n := v.Int64()
+		if v.Kind() == parquet.Int32 {
+			n = int64(v.Int32())
+		}
+		switch {
+		case lt.Date != nil:
+			return time.Unix(n*86400, 0).UTC().Format("2006-01-02"), nil
+		case lt.Timestamp != nil:
+			return timestampText(n, lt.Timestamp.Unit), nil
+		case lt.Time != nil:
+			return time.Unix(0, 0).UTC().Add(time.Duration(n) * timeUnitDuration(lt.Time.Unit)).Format("15:04:05.999999999"), nil
+		case lt.Decimal != nil:
+			return json.Number(decimal.New(n, -lt.Decimal.Scale).String()), nil
+		case lt.Integer != nil && !lt.Integer.IsSigned:
+			if v.Kind() == parquet.Int32 {
+				return json.Number(strconv.FormatUint(uint64(v.Uint32()), 10)), nil
+			}
+			return json.Number(strconv.FormatUint(v.Uint64(), 10)), nil
+		}
+		return json.Number(strconv.FormatInt(n, 10)), nil
+	case parquet.Int96:
+		i := v.Int96()
+		nanos := int64(uint64(i[1])<<32 | uint64(i[0]))
+		days := int64(i[2]) - julianUnixEpoch
+		return time.Unix(days*86400, nanos).UTC().Format(time.RFC3339Nano), nil
+	case parquet.Float:
+		return floatValue(float64(v.Float()), 32), nil
+	case parquet.Double:
+		return floatValue(v.Double(), 64), nil
+	}
+
+	data := v.ByteArray()
+	switch {
+	case lt.Decimal != nil:
+		return json.Number(decimal.NewFromBigInt(fromTwosComplement(data), -lt.Decimal.Scale).String()), nil
+	case lt.UUID != nil && len(data) == 16:
+		return uuid.UUID(data).String(), nil
+	case lt.Json != nil:
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		var value interface{}
+		if err := dec.Decode(&value); err == nil {
+			return value, nil
+		}
+	}
+	return string(data), nil
+}
+
+func timestampText(n int64, unit format.TimeUnit) string {
+	var tm time.Time
+	switch {
+	case unit.Millis != nil:
+		tm = time.UnixMilli(n)
+	case unit.Micros != nil:
+		tm = time.UnixMicro(n)
+	default:
+		tm = time.Unix(0, n)
+	}
+	return tm.UTC().Format(time.RFC3339Nano)
+}
+
+func timeUnitDuration(unit format.TimeUnit) time.Duration {
+	switch {
+	case unit.Millis != nil:
+		return time.Millisecond
+	case unit.Micros != nil:
+		return time.Microsecond
+	}
+	return time.Nanosecond
+}
+
+// floatValue keeps finite floats numbers; NaN and infinities, which JSON
+// cannot hold, become the text ClickHouse uses for them.
+func floatValue(f float64, bits int) interface{} {
+	switch {
+	case math.IsNaN(f):
+		return "nan"
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	}
+	return json.Number(strconv.FormatFloat(f, 'g', -1, bits))
+}
+
+// fromTwosComplement reads a big-endian two's complement integer.
+func fromTwosComplement(b []byte) *big.Int {
+	n := new(big.Int).SetBytes(b)
+	if len(b) > 0 && b[0]&0x80 != 0 {
+		n.Sub(n, new(big.Int).Lsh(big.NewInt(1), uint(len(b)*8)))
+	}
+	return n
+}
@@ -0,0 +1,383 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/AditiKulkarni9/clickhouse-flatfile-tool/chtypes"
+	"github.com/google/uuid"
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/compress"
+	"github.com/parquet-go/parquet-go/encoding"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	// DefaultRowGroupSize is the number of rows per Parquet row group.
+	DefaultRowGroupSize = 100000
+	// DefaultParquetCompression is the codec Parquet columns are compressed with.
+	DefaultParquetCompression = "snappy"
+)
+
+// parquetCodecs are the compression codecs Parquet exports may use.
+var parquetCodecs = map[string]compress.Codec{
+	"snappy":       &parquet.Snappy,
+	"zstd":         &parquet.Zstd,
+	"none":         &parquet.Uncompressed,
+	"uncompressed": &parquet.Uncompressed,
+}
+
+// ParquetCodec returns the named Parquet compression codec; an empty name
+// selects DefaultParquetCompression.
+func ParquetCodec(name string) (compress.Codec, error) {
+	if name == "" {
+		name = DefaultParquetCompression
+	}
+	codec, ok := parquetCodecs[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown Parquet compression %q: expected snappy, zstd or none", name)
+	}
+	return codec, nil
+}
+
+// parquetRowWriter writes an export as a Parquet file. Each ClickHouse column
+// becomes one Parquet column: Nullable types are optional, Arrays are lists,
+// and Map, Tuple and Nested values are stored as JSON.
+type parquetRowWriter struct {
+	columns []ExportColumn
+	writer  *parquet.Writer
+	row     parquet.Row
+}
+
+func newParquetRowWriter(w io.Writer, columns []ExportColumn, opts ExportOptions) (RowWriter, error) {
+	codec, err := ParquetCodec(opts.Compression)
+	if err != nil {
+		return nil, err
+	}
+	rowGroupSize := opts.RowGroupSize
+	if rowGroupSize <= 0 {
+		rowGroupSize = DefaultRowGroupSize
+	}
+
+	fields := make(parquetGroup, len(columns))
+	for i, col := range columns {
+		node, err := parquetNode(col.Type)
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %v", col.Name, err)
+		}
+		fields[i] = parquetField{Node: node, name: col.Name}
+	}
+	schema := parquet.NewSchema("export", fields)
+	writer := parquet.NewWriter(w, schema, parquet.Compression(codec), parquet.MaxRowsPerRowGroup(int64(rowGroupSize)))
+	return &parquetRowWriter{columns: columns, writer: writer}, nil
+}
+
+func (p *parquetRowWriter) WriteRow(values []interface{}) (int64, error) {
+	p.row = p.row[:0]
+	for i, col := range p.columns {
+		var err error
+		p.row, err = appendParquetValues(p.row, col.Type, reflect.ValueOf(values[i]), 0, 0, 0, i)
+		if err != nil {
+			return 0, fmt.Errorf("column %s: %v", col.Name, err)
+		}
+	}
+	if _, err := p.writer.WriteRows([]parquet.Row{p.row}); err != nil {
+		return 0, fmt.Errorf("failed to write Parquet row: %v", err)
+	}
+	var size int64
+	for _, v := range p.row {
+		size += parquetValueSize(v)
+	}
+	return size, nil
+}
+
+// Flush does nothing: rows reach the underlying writer a row group at a
+// time, and flushing earlier would only make the row groups smaller.
+func (p *parquetRowWriter) Flush() error {
+	return nil
+}
+
+func (p *parquetRowWriter) Close() error {
+	if err := p.writer.Close(); err != nil {
+		return fmt.Errorf("failed to write Parquet: %v", err)
+	}
+	return nil
+}
+
+// parquetNode returns the Parquet column for ClickHouse type t.
+func parquetNode(t *chtypes.Type) (parquet.Node, error) {
+	var node parquet.Node
+	switch t.Kind {
+	case chtypes.Array:
+		elem, err := parquetNode(t.Elem)
+		if err != nil {
+			return nil, err
+		}
+		node = parquet.List(elem)
+	case chtypes.Map, chtypes.Tuple, chtypes.Nested:
+		node = parquet.JSON()
+	case chtypes.Int8:
+		node = parquet.Int(8)
+	case chtypes.Int16:
+		node = parquet.Int(16)
+	case chtypes.Int32:
+		node = parquet.Int(32)
+	case chtypes.Int64:
+		node = parquet.Int(64)
+	case chtypes.UInt8:
+		node = parquet.Uint(8)
+	case chtypes.UInt16:
+		node = parquet.Uint(16)
+	case chtypes.UInt32:
+		node = parquet.Uint(32)
+	case chtypes.UInt64:
+		node = parquet.Uint(64)
+	case chtypes.Float32:
+		node = parquet.Leaf(parquet.FloatType)
+	case chtypes.Float64:
+		node = parquet.Leaf(parquet.DoubleType)
+	case chtypes.Decimal:
+		switch {
+		case t.Precision <= 9:
+			node = parquet.Decimal(t.Scale, t.Precision, parquet.Int32Type)
+		case t.Precision <= 18:
+			node = parquet.Decimal(t.Scale, t.Precision, parquet.Int64Type)
+		default:
+			node = parquet.Decimal(t.Scale, t.Precision, parquet.FixedLenByteArrayType(decimalBytes(t.Precision)))
+		}
+	case chtypes.Bool:
+		node = parquet.Leaf(parquet.BooleanType)
+	case chtypes.String, chtypes.FixedString, chtypes.Enum8, chtypes.Enum16,
+		chtypes.IPv4, chtypes.IPv6, chtypes.Int128, chtypes.Int256, chtypes.UInt128, chtypes.UInt256:
+		node = parquet.String()
+	case chtypes.UUID:
+		node = parquet.UUID()
+	case chtypes.Date, chtypes.Date32:
+		node = parquet.Date()
+	case chtypes.DateTime:
+		node = parquet.Timestamp(parquet.Millisecond)
+	case chtypes.DateTime64:
+		node = parquet.Timestamp(timestampUnit(t.Precision))
+	default:
+		return nil, fmt.Errorf("unsupported ClickHouse type %s", t)
+	}
+	if t.Nullable {
+		node = parquet.Optional(node)
+	}
+	return node, nil
+}
+
+func timestampUnit(precision int) parquet.TimeUnit {
+	switch {
+	case precision <= 3:
+		return parquet.Millisecond
+	case precision <= 6:
+		return parquet.Microsecond
+	}
+	return parquet.Nanosecond
+}
+
+// decimalBytes is the size of the smallest two's complement integer that
+// holds every unscaled value of the given decimal precision.
+func decimalBytes(precision int) int {
+	limit := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(precision)), nil)
+	return limit.BitLen()/8 + 1
+}
+
+// appendParquetValues appends the leaf values of rv to row. rep is the
+// repetition level of the first value, def the definition level of the
+// enclosing lists and depth the number of enclosing lists.
+func appendParquetValues(row parquet.Row, t *chtypes.Type, rv reflect.Value, rep, def, depth, column int) (parquet.Row, error) {
+	for rv.IsValid() && (rv.Kind() == reflect.Pointer || rv.Kind() == reflect.Interface) {
+		if rv.IsNil() {
+			rv = reflect.Value{}
+			break
+		}
+		rv = rv.Elem()
+	}
+
+	if t.Kind == chtypes.Array {
+		if !rv.IsValid() || rv.Len() == 0 {
+			return append(row, parquet.NullValue().Level(rep, def, column)), nil
+		}
+		for i := 0; i < rv.Len(); i++ {
+			if i > 0 {
+				rep = depth + 1
+			}
+			var err error
+			row, err = appendParquetValues(row, t.Elem, rv.Index(i), rep, def+1, depth+1, column)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return row, nil
+	}
+
+	if !rv.IsValid() {
+		if !t.Nullable {
+			return nil, fmt.Errorf("NULL value for non-nullable type %s", t)
+		}
+		return append(row, parquet.NullValue().Level(rep, def, column)), nil
+	}
+	if t.Nullable {
+		def++
+	}
+	value, err := parquetValue(t, rv)
+	if err != nil {
+		return nil, err
+	}
+	return append(row, value.Level(rep, def, column)), nil
+}
+
+// parquetValue converts a non-NULL scalar, or a Map, Tuple or Nested value,
+// to the Parquet value of the column parquetNode returns for t.
+func parquetValue(t *chtypes.Type, rv reflect.Value) (parquet.Value, error) {
+	switch t.Kind {
+	case chtypes.Map, chtypes.Tuple, chtypes.Nested:
+		v, err := t.JSONValue(rv.Interface())
+		if err != nil {
+			return parquet.Value{}, err
+		}
+		data, err := json.Marshal(v)
+		if err != nil {
+			return parquet.Value{}, err
+		}
+		return parquet.ByteArrayValue(data), nil
+	case chtypes.Int8, chtypes.Int16, chtypes.Int32:
+		return parquet.Int32Value(int32(rv.Int())), nil
+	case chtypes.Int64:
+		return parquet.Int64Value(rv.Int()), nil
+	case chtypes.UInt8, chtypes.UInt16, chtypes.UInt32:
+		return parquet.Int32Value(int32(uint32(rv.Uint()))), nil
+	case chtypes.UInt64:
+		return parquet.Int64Value(int64(rv.Uint())), nil
+	case chtypes.Float32:
+		return parquet.FloatValue(float32(rv.Float())), nil
+	case chtypes.Float64:
+		return parquet.DoubleValue(rv.Float()), nil
+	case chtypes.Bool:
+		return parquet.BooleanValue(rv.Bool()), nil
+	case chtypes.Decimal:
+		d, ok := rv.Interface().(decimal.Decimal)
+		if !ok {
+			return parquet.Value{}, fmt.Errorf("cannot write %s as %s", rv.Type(), t)
+		}
+		unscaled := d.Shift(int32(t.Scale)).BigInt()
+		switch {
+		case t.Precision <= 9:
+			return parquet.Int32Value(int32(unscaled.Int64())), nil
+		case t.Precision <= 18:
+			return parquet.Int64Value(unscaled.Int64()), nil
+		}
+		return parquet.FixedLenByteArrayValue(twosComplement(unscaled, decimalBytes(t.Precision))), nil
+	case chtypes.UUID:
+		u, ok := rv.Interface().(uuid.UUID)
+		if !ok {
+			return parquet.Value{}, fmt.Errorf("cannot write %s as %s", rv.Type(), t)
+		}
+		return parquet.FixedLenByteArrayValue(u[:]), nil
+	case chtypes.Date, chtypes.Date32:
+		tm, ok := rv.Interface().(time.Time)
+		if !ok {
+			return parquet.Value{}, fmt.Errorf("cannot write %s as %s", rv.Type(), t)
+		}
+		day := time.Date(tm.Year(), tm.Month(), tm.Day(), 0, 0, 0, 0, time.UTC)
+		return parquet.Int32Value(int32(day.Unix() / 86400)), nil
+	case chtypes.DateTime, chtypes.DateTime64:
+		tm, ok := rv.Interface().(time.Time)
+		if !ok {
+			return parquet.Value{}, fmt.Errorf("cannot write %s as %s", rv.Type(), t)
+		}
+		unit := parquet.Millisecond
+		if t.Kind == chtypes.DateTime64 {
+			unit = timestampUnit(t.Precision)
+		}
+		switch unit {
+		case parquet.Millisecond:
+			return parquet.Int64Value(tm.UnixMilli()), nil
+		case parquet.Microsecond:
+			return parquet.Int64Value(tm.UnixMicro()), nil
+		}
+		return parquet.Int64Value(tm.UnixNano()), nil
+	}
+	text, err := t.Encode(rv.Interface())
+	if err != nil {
+		return parquet.Value{}, err
+	}
+	return parquet.ByteArrayValue([]byte(text)), nil
+}
+
+// twosComplement returns n as a big-endian two's complement integer of size
+// bytes.
+func twosComplement(n *big.Int, size int) []byte {
+	b := make([]byte, size)
+	if n.Sign() >= 0 {
+		return n.FillBytes(b)
+	}
+	// -n = ^(n-1), so the bytes of n-1 are inverted.
+	abs := new(big.Int).Neg(n)
+	abs.Sub(abs, big.NewInt(1)).FillBytes(b)
+	for i := range b {
+		b[i] = ^b[i]
+	}
+	return b
+}
+
+func parquetValueSize(v parquet.Value) int64 {
+	switch v.Kind() {
+	case parquet.Boolean:
+		return 1
+	case parquet.Int32, parquet.Float:
+		return 4
+	case parquet.Int64, parquet.Double:
+		return 8
+	case parquet.ByteArray, parquet.FixedLenByteArray:
+		return int64(len(v.ByteArray()))
+	}
+	return 0
+}
+
+// parquetGroup is the root of an export schema. Unlike parquet.Group, which
+// sorts its fields by name, it keeps the columns in the order selected.
+type parquetGroup []parquetField
+
+type parquetField struct {
+	parquet.Node
+	name string
+}
+
+func (f parquetField) Name() string { return f.name }
+
+// Value is not needed: rows are built from the scanned values directly.
+func (f parquetField) Value(base reflect.Value) reflect.Value { return reflect.Value{} }
+
+func (g parquetGroup) ID() int                     { return 0 }
+func (g parquetGroup) Type() parquet.Type          { return parquet.Group{}.Type() }
+func (g parquetGroup) Optional() bool              { return false }
+func (g parquetGroup) Repeated() bool              { return false }
+func (g parquetGroup) Required() bool              { return true }
+func (g parquetGroup) Leaf() bool                  { return false }
+func (g parquetGroup) Encoding() encoding.Encoding { return nil }
+func (g parquetGroup) Compression() compress.Codec { return nil }
+func (g parquetGroup) GoType() reflect.Type        { return reflect.TypeOf(map[string]interface{}{}) }
+
+func (g parquetGroup) Fields() []parquet.Field {
+	fields := make([]parquet.Field, len(g))
+	for i, f := range g {
+		fields[i] = f
+	}
+	return fields
+}
+
+func (g parquetGroup) String() string {
+	names := make([]string, len(g))
+	for i, f := range g {
+		names[i] = f.name
+	}
+	return "group{" + strings.Join(names, ", ") + "}"
+}
@@ -0,0 +1,101 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrMalformedRecord is returned with a record that could not be parsed,
+// such as a line of a JSON Lines file that is not a JSON object. The record
+// then holds the raw line.
+var ErrMalformedRecord = errors.New("malformed record")
+
+// RecordReader reads the records of an uploaded file, whatever its type.
+// Fields of delimited files are strings; other types have typed values,
+// with nil for nulls.
+type RecordReader interface {
+	// Header returns the column names. It must be called before Read.
+	Header() ([]string, error)
+	// Read returns the next record, or io.EOF at the end of the file. A
+	// record with the wrong number of fields is returned together with
+	// ErrFieldCount, and one that cannot be parsed with ErrMalformedRecord.
+	Read() ([]interface{}, error)
+	// Line returns where the last record read starts: its line for
+	// delimited files and its row number for others.
+	Line() int
+	// TotalRows returns the number of records in the file, or 0 if it is
+	// not known without reading them.
+	TotalRows() int64
+	Close() error
+}
+
+// OpenRecords opens the file at path in the given format, decompressing it
+// if it is compressed. columns, if set, are the columns the caller needs;
+// formats that store columns apart only read those, others ignore it.
+func OpenRecords(path string, format FileFormat, columns []string) (RecordReader, error) {
+	switch format.Type {
+	case FileTypeParquet:
+		return openParquetRecords(path, columns)
+	case FileTypeNDJSON:
+		return openNDJSONRecords(path, format.Compression, columns)
+	}
+	file, err := openDecompressed(path, format.Compression)
+	if err != nil {
+		return nil, err
+	}
+	return &csvRecords{file: file, reader: NewCSVReader(file, format)}, nil
+}
+
+// csvRecords is a RecordReader of a delimited file.
+type csvRecords struct {
+	file   io.ReadCloser
+	reader *CSVReader
+}
+
+func (r *csvRecords) Header() ([]string, error) { return r.reader.Header() }
+
+func (r *csvRecords) Read() ([]interface{}, error) {
+	fields, err := r.reader.Read()
+	if fields == nil {
+		return nil, err
+	}
+	record := make([]interface{}, len(fields))
+	for i, field := range fields {
+		record[i] = field
+	}
+	return record, err
+}
+
+func (r *csvRecords) Line() int { return r.reader.Line() }
+
+func (r *csvRecords) TotalRows() int64 { return 0 }
+
+func (r *csvRecords) Close() error { return r.file.Close() }
+
+// RecordText returns the fields of a record as text, for previews and
+// rejects: strings as they are, nulls as \N and anything else as JSON.
+func RecordText(record []interface{}) []string {
+	text := make([]string, len(record))
+	for i, v := range record {
+		text[i] = valueText(v)
+	}
+	return text
+}
+
+func valueText(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return `\N`
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
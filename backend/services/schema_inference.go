@@ -0,0 +1,339 @@
+package services
+
+import (
+	"errors"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AditiKulkarni9/clickhouse-flatfile-tool/chtypes"
+	"github.com/AditiKulkarni9/clickhouse-flatfile-tool/models"
+	"github.com/google/uuid"
+)
+
+const (
+	// DefaultSampleRows is how many rows are inspected to infer column types.
+	DefaultSampleRows = 1000
+	// MaxSampleRows bounds the sample size a request may ask for.
+	MaxSampleRows = 100000
+
+	// lowCardinalityRatio is the share of distinct values below which a
+	// String column is proposed as LowCardinality(String).
+	lowCardinalityRatio = 0.1
+	// lowCardinalityMinValues is the number of values needed to judge that.
+	lowCardinalityMinValues = 20
+	// maxDistinct is how many distinct values are tracked per column.
+	maxDistinct = 10000
+	// maxExamples is how many example values are returned per column.
+	maxExamples = 3
+)
+
+// InferredColumn is a flat file column with the ClickHouse type proposed for
+// it. Confidence grows with the number of values the type was checked
+// against and is 1 when the whole file was sampled. Examples are some of
+// the values seen.
+type InferredColumn struct {
+	models.Column
+	Confidence float64  `json:"confidence"`
+	Examples   []string `json:"examples"`
+}
+
+var dateTimeLayouts = []string{"2006-01-02 15:04:05", "2006-01-02T15:04:05", time.RFC3339Nano}
+
+// columnStats accumulates what the values of one column could be parsed as.
+// Every candidate type starts out possible and is ruled out by the first
+// value it cannot represent.
+type columnStats struct {
+	values   int // non-blank values
+	blanks   int // empty values
+	nulls    int // \N values
+	examples []string
+	distinct map[string]struct{} // nil once more than maxDistinct values were seen
+
+	isBool, isInt, isDecimal, isFloat  bool
+	isDate, isDateTime, isUUID, isIPv4 bool
+
+	negative      bool
+	minInt        int64
+	maxUint       uint64
+	intDigits     int // most digits before the decimal point
+	scale         int // digits after the decimal point, if all values agree
+	fractionDigit int // most sub-second digits of a DateTime
+}
+
+func newColumnStats() *columnStats {
+	s := &columnStats{distinct: make(map[string]struct{}), scale: -1}
+	s.isBool, s.isInt, s.isDecimal, s.isFloat = true, true, true, true
+	s.isDate, s.isDateTime, s.isUUID, s.isIPv4 = true, true, true, true
+	return s
+}
+
+func (s *columnStats) observe(value string) {
+	if value == chtypes.NullText {
+		s.nulls++
+		return
+	}
+	v := strings.TrimSpace(value)
+	if v == "" {
+		s.blanks++
+		return
+	}
+
+	s.values++
+	if s.distinct != nil {
+		if _, seen := s.distinct[value]; !seen {
+			if len(s.examples) < maxExamples {
+				s.examples = append(s.examples, value)
+			}
+			s.distinct[value] = struct{}{}
+			if len(s.distinct) > maxDistinct {
+				s.distinct = nil
+			}
+		}
+	}
+
+	if s.isBool {
+		lower := strings.ToLower(v)
+		s.isBool = lower == "true" || lower == "false"
+	}
+	s.observeNumber(v)
+	if s.isDate {
+		_, err := time.Parse("2006-01-02", v)
+		s.isDate = err == nil
+	}
+	if s.isDateTime {
+		s.observeDateTime(v)
+	}
+	if s.isUUID {
+		_, err := uuid.Parse(v)
+		s.isUUID = err == nil && len(v) == 36
+	}
+	if s.isIPv4 {
+		s.isIPv4 = isIPv4(v)
+	}
+}
+
+func (s *columnStats) observeNumber(v string) {
+	if !s.isInt && !s.isDecimal && !s.isFloat {
+		return
+	}
+	digits := strings.TrimLeft(v, "+-")
+	// Numbers with leading zeros are identifiers such as postcodes.
+	if len(digits) > 1 && digits[0] == '0' && digits[1] != '.' {
+		s.isInt, s.isDecimal, s.isFloat = false, false, false
+		return
+	}
+	if strings.ContainsFunc(digits, func(r rune) bool { return !strings.ContainsRune("0123456789.eE+-", r) }) {
+		s.isInt, s.isDecimal, s.isFloat = false, false, false
+		return
+	}
+	if s.isFloat {
+		_, err := strconv.ParseFloat(v, 64)
+		s.isFloat = err == nil
+	}
+
+	if s.isInt {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			if n < 0 {
+				s.negative = true
+				s.minInt = min(s.minInt, n)
+			} else {
+				s.maxUint = max(s.maxUint, uint64(n))
+			}
+		} else if n, err := strconv.ParseUint(v, 10, 64); err == nil {
+			s.maxUint = max(s.maxUint, n)
+		} else {
+			s.isInt = false
+		}
+	}
+
+	if s.isDecimal {
+		whole, fraction, _ := strings.Cut(digits, ".")
+		if whole == "" || !allDigits(whole) || !allDigits(fraction) || strings.Count(v, "-")+strings.Count(v, "+") > 1 {
+			s.isDecimal = false
+			return
+		}
+		s.intDigits = max(s.intDigits, len(whole))
+		switch {
+		case s.scale == -1:
+			s.scale = len(fraction)
+		case s.scale != len(fraction):
+			// Values with varying decimal places are measurements, not
+			// fixed-point amounts.
+			s.isDecimal = false
+		}
+	}
+}
+
+func (s *columnStats) observeDateTime(v string) {
+	for _, layout := range dateTimeLayouts {
+		if _, err := time.Parse(layout, v); err == nil {
+			if dot := strings.IndexByte(v, '.'); dot >= 0 {
+				end := dot + 1
+				for end < len(v) && v[end] >= '0' && v[end] <= '9' {
+					end++
+				}
+				s.fractionDigit = max(s.fractionDigit, end-dot-1)
+			}
+			return
+		}
+	}
+	s.isDateTime = false
+}
+
+// infer proposes a type for the column. complete tells whether every row of
+// the file was sampled.
+func (s *columnStats) infer(name string, complete bool) InferredColumn {
+	column := InferredColumn{Column: models.Column{Name: name}, Examples: s.examples}
+	if column.Examples == nil {
+		column.Examples = []string{}
+	}
+	if s.values == 0 {
+		column.Type = "Nullable(String)"
+		return column
+	}
+
+	typ := s.baseType()
+	switch {
+	case typ == "String" && s.lowCardinality():
+		if s.nulls > 0 {
+			typ = "Nullable(String)"
+		}
+		typ = "LowCardinality(" + typ + ")"
+	case typ == "String":
+		// Empty strings are valid strings; only \N marks a NULL.
+		if s.nulls > 0 {
+			typ = "Nullable(String)"
+		}
+	case s.nulls > 0 || s.blanks > 0:
+		typ = "Nullable(" + typ + ")"
+	}
+	column.Type = typ
+
+	column.Confidence = 1
+	if !complete {
+		column.Confidence = math.Round(float64(s.values)/float64(s.values+10)*100) / 100
+	}
+	return column
+}
+
+func (s *columnStats) baseType() string {
+	switch {
+	case s.isBool:
+		return "Bool"
+	case s.isInt:
+		return s.intType()
+	case s.isDecimal && s.scale > 0:
+		// Precision is rounded up to what the underlying integer holds anyway.
+		precision := s.intDigits + s.scale
+		for _, limit := range []int{9, 18, 38, 76} {
+			if precision <= limit {
+				return "Decimal(" + strconv.Itoa(limit) + ", " + strconv.Itoa(s.scale) + ")"
+			}
+		}
+		return "Float64"
+	case s.isFloat:
+		return "Float64"
+	case s.isDate:
+		return "Date"
+	case s.isDateTime && s.fractionDigit > 0:
+		return "DateTime64(" + strconv.Itoa(min((s.fractionDigit+2)/3*3, 9)) + ")"
+	case s.isDateTime:
+		return "DateTime"
+	case s.isUUID:
+		return "UUID"
+	case s.isIPv4:
+		return "IPv4"
+	}
+	return "String"
+}
+
+// intType returns the smallest integer type holding every value seen.
+func (s *columnStats) intType() string {
+	if !s.negative {
+		switch {
+		case s.maxUint <= math.MaxUint8:
+			return "UInt8"
+		case s.maxUint <= math.MaxUint16:
+			return "UInt16"
+		case s.maxUint <= math.MaxUint32:
+			return "UInt32"
+		}
+		return "UInt64"
+	}
+	switch {
+	case s.maxUint > math.MaxInt64:
+		return "Int128"
+	case s.minInt >= math.MinInt8 && s.maxUint <= math.MaxInt8:
+		return "Int8"
+	case s.minInt >= math.MinInt16 && s.maxUint <= math.MaxInt16:
+		return "Int16"
+	case s.minInt >= math.MinInt32 && s.maxUint <= math.MaxInt32:
+		return "Int32"
+	}
+	return "Int64"
+}
+
+func (s *columnStats) lowCardinality() bool {
+	return s.distinct != nil && s.values >= lowCardinalityMinValues &&
+		float64(len(s.distinct)) <= float64(s.values)*lowCardinalityRatio
+}
+
+func allDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func isIPv4(s string) bool {
+	parts := strings.Split(s, ".")
+	if len(parts) != 4 {
+		return false
+	}
+	for _, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 || n > 255 || !allDigits(part) || (len(part) > 1 && part[0] == '0') {
+			return false
+		}
+	}
+	return true
+}
+
+// InferColumns reads up to sampleRows records of reader and proposes a type
+// for each of the columns named in header. Records with the wrong number of
+// fields are left out of the sample.
+func InferColumns(reader *CSVReader, header []string, sampleRows int) ([]InferredColumn, error) {
+	stats := make([]*columnStats, len(header))
+	for i := range stats {
+		stats[i] = newColumnStats()
+	}
+
+	complete := false
+	for rows := 0; rows < sampleRows; rows++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			complete = true
+			break
+		}
+		if errors.Is(err, ErrFieldCount) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for i, value := range record {
+			stats[i].observe(value)
+		}
+	}
+
+	columns := make([]InferredColumn, len(header))
+	for i, name := range header {
+		columns[i] = stats[i].infer(name, complete)
+	}
+	return columns, nil
+}
@@ -0,0 +1,92 @@
+package services
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestInferColumnType(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []string
+		want   string
+	}{
+		{"no values", []string{"", `\N`}, "Nullable(String)"},
+		{"bool", []string{"true", "FALSE", "True"}, "Bool"},
+		{"uint8", []string{"0", "7", "255"}, "UInt8"},
+		{"uint16", []string{"1", "256"}, "UInt16"},
+		{"uint32", []string{"70000"}, "UInt32"},
+		{"uint64", []string{"18446744073709551615"}, "UInt64"},
+		{"int8", []string{"-128", "127"}, "Int8"},
+		{"int16", []string{"-129", "5"}, "Int16"},
+		{"int32", []string{"-40000"}, "Int32"},
+		{"int64", []string{"-3000000000"}, "Int64"},
+		{"int128", []string{"-1", "18446744073709551615"}, "Int128"},
+		{"leading zeros stay strings", []string{"01234", "98765"}, "String"},
+		{"zero point is a number", []string{"0.50", "1.25"}, "Decimal(9, 2)"},
+		{"wide decimal", []string{"12345678901.25"}, "Decimal(18, 2)"},
+		{"mixed scale is float", []string{"1.5", "2.25"}, "Float64"},
+		{"exponent is float", []string{"1e3", "2.5"}, "Float64"},
+		{"date", []string{"2026-01-02", "2025-12-31"}, "Date"},
+		{"datetime", []string{"2026-01-02 03:04:05", "2026-01-02T03:04:05"}, "DateTime"},
+		{"datetime64", []string{"2026-01-02T03:04:05.1234Z"}, "DateTime64(6)"},
+		{"uuid", []string{"123e4567-e89b-12d3-a456-426614174000"}, "UUID"},
+		{"ipv4", []string{"10.0.0.1", "192.168.1.255"}, "IPv4"},
+		{"bad ipv4", []string{"10.0.0.256"}, "String"},
+		{"nullable int", []string{"1", `\N`}, "Nullable(UInt8)"},
+		{"blank makes numbers nullable", []string{"1", ""}, "Nullable(UInt8)"},
+		{"blank string stays a string", []string{"a", ""}, "String"},
+		{"nullable string", []string{"a", `\N`}, "Nullable(String)"},
+		{"mixed", []string{"1", "x"}, "String"},
+		{"low cardinality", strings.Split(strings.Repeat("red,blue,", 10), ",")[:20], "LowCardinality(String)"},
+		{"low cardinality nullable", append(strings.Split(strings.Repeat("red,blue,", 10), ",")[:20], `\N`), "LowCardinality(Nullable(String))"},
+		{"too few for low cardinality", []string{"red", "red", "red"}, "String"},
+	}
+	for _, tt := range tests {
+		stats := newColumnStats()
+		for _, v := range tt.values {
+			stats.observe(v)
+		}
+		if got := stats.infer("c", true).Type; got != tt.want {
+			t.Errorf("%s: type = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestInferColumns(t *testing.T) {
+	// The second record has too few fields and is left out of the sample.
+	data := "id,name,price\n1,apple,0.50\n2,pear\n3,plum,1.25\n3,plum,1.25\n"
+	tests := []struct {
+		sampleRows int
+		confidence float64
+		examples   []string
+	}{
+		{sampleRows: 100, confidence: 1, examples: []string{"apple", "plum"}},
+		{sampleRows: 2, confidence: 0.09, examples: []string{"apple"}},
+	}
+	for _, tt := range tests {
+		reader := NewCSVReader(strings.NewReader(data), DefaultFileFormat)
+		header, err := reader.Header()
+		if err != nil {
+			t.Fatal(err)
+		}
+		columns, err := InferColumns(reader, header, tt.sampleRows)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var types []string
+		for _, col := range columns {
+			types = append(types, col.Name+" "+col.Type)
+			if col.Confidence != tt.confidence {
+				t.Errorf("%d rows: %s confidence = %v, want %v", tt.sampleRows, col.Name, col.Confidence, tt.confidence)
+			}
+		}
+		if want := []string{"id UInt8", "name String", "price Decimal(9, 2)"}; !reflect.DeepEqual(types, want) {
+			t.Errorf("%d rows: columns %v, want %v", tt.sampleRows, types, want)
+		}
+		if !reflect.DeepEqual(columns[1].Examples, tt.examples) {
+			t.Errorf("%d rows: examples = %v, want %v", tt.sampleRows, columns[1].Examples, tt.examples)
+		}
+	}
+}
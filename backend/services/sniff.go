@@ -0,0 +1,377 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// File types an upload can hold.
+const (
+	FileTypeCSV     = "csv"
+	FileTypeParquet = "parquet"
+	FileTypeNDJSON  = "ndjson"
+)
+
+// FileFormat describes how a flat file is laid out. Uploads carry the format
+// detected by DetectFormat; requests may override parts of it. The other
+// fields only apply to delimited files.
+type FileFormat struct {
+	// Type is FileTypeCSV, FileTypeParquet or FileTypeNDJSON. Uploads stored
+	// before other types were supported have none, which means CSV.
+	Type string `json:"type,omitempty"`
+	// Compression is the codec the file is compressed with, such as "gzip",
+	// or empty if it is not compressed.
+	Compression string `json:"compression,omitempty"`
+	Delimiter   string `json:"delimiter"`
+	// Quote encloses fields containing the delimiter or line breaks. It is
+	// empty when fields are never quoted.
+	Quote     string `json:"quote"`
+	HasHeader bool   `json:"hasHeader"`
+	// LineEnding is "\n", "\r\n" or "\r".
+	LineEnding string `json:"lineEnding"`
+	BOM        bool   `json:"bom"`
+	// Encoding is "utf-8", "utf-16le", "utf-16be", "iso-8859-1" or
+	// "windows-1252". Readers transcode other encodings to UTF-8.
+	Encoding string `json:"encoding"`
+}
+
+// DefaultFileFormat is a comma separated UTF-8 file with a header.
+var DefaultFileFormat = FileFormat{
+	Type:       FileTypeCSV,
+	Delimiter:  ",",
+	Quote:      `"`,
+	HasHeader:  true,
+	LineEnding: "\n",
+	Encoding:   "utf-8",
+}
+
+// Validate checks that the format can be read.
+func (f FileFormat) Validate() error {
+	if _, err := LookupCodec(f.Compression); err != nil {
+		return err
+	}
+	switch f.Type {
+	case "", FileTypeCSV:
+	case FileTypeParquet:
+		if f.Compression != "" {
+			return fmt.Errorf("%s compressed Parquet files are not supported; Parquet compresses its columns itself", f.Compression)
+		}
+		return nil
+	case FileTypeNDJSON:
+		return nil
+	default:
+		return fmt.Errorf("unknown file type %q: expected csv, parquet or ndjson", f.Type)
+	}
+	if utf8.RuneCountInString(f.Delimiter) != 1 || !validSeparator(f.Delimiter) {
+		return fmt.Errorf("delimiter must be a single character other than a line break")
+	}
+	if f.Quote != "" && (utf8.RuneCountInString(f.Quote) != 1 || !validSeparator(f.Quote)) {
+		return fmt.Errorf("quote must be empty or a single character other than a line break")
+	}
+	if f.Quote == f.Delimiter {
+		return fmt.Errorf("quote and delimiter must differ")
+	}
+	switch f.LineEnding {
+	case "", "\n", "\r\n", "\r":
+	default:
+		return fmt.Errorf("unsupported line ending %q", f.LineEnding)
+	}
+	_, err := NormalizeEncoding(f.Encoding)
+	return err
+}
+
+func validSeparator(s string) bool {
+	r, _ := utf8.DecodeRuneInString(s)
+	return r != utf8.RuneError && r != '\r' && r != '\n'
+}
+
+// delimiterCandidates are the field separators DetectFormat chooses from,
+// in order of preference when counts tie.
+var delimiterCandidates = []byte{',', ';', '\t', '|'}
+
+// quoteCandidates are the quote characters DetectFormat chooses from; the
+// first wins unless another quotes more fields.
+var quoteCandidates = []byte{'"', '\''}
+
+// sniffLines is the number of lines DetectFormat looks at.
+const sniffLines = 20
+
+// DetectFormat guesses the format of a flat file from a sample of its first
+// bytes. Anything it cannot tell falls back to DefaultFileFormat.
+func DetectFormat(sample []byte) FileFormat {
+	if bytes.HasPrefix(sample, []byte(parquetMagic)) {
+		return FileFormat{Type: FileTypeParquet}
+	}
+	if isNDJSON(sample) {
+		return FileFormat{Type: FileTypeNDJSON}
+	}
+	format := DefaultFileFormat
+	var body []byte
+	format.Encoding, format.BOM, body = detectEncoding(sample)
+	format.LineEnding = detectLineEnding(body)
+
+	lines := sampleLines(body, format.LineEnding)
+	if len(lines) == 0 {
+		return format
+	}
+	format.Quote = string(detectQuote(lines))
+	format.Delimiter = string(detectDelimiter(lines, format.Quote[0]))
+	format.HasHeader = detectHeader(body, format)
+	return format
+}
+
+// detectEncoding recognises byte order marks, UTF-16 without one by its zero
+// bytes, and otherwise tells valid UTF-8 from single-byte encodings. It
+// returns the sample without BOM, decoded to UTF-8 if it was UTF-16.
+func detectEncoding(sample []byte) (encoding string, bom bool, body []byte) {
+	switch {
+	case bytes.HasPrefix(sample, []byte{0xEF, 0xBB, 0xBF}):
+		return "utf-8", true, sample[3:]
+	case bytes.HasPrefix(sample, []byte{0xFF, 0xFE}):
+		return "utf-16le", true, decodeUTF16(sample[2:], false)
+	case bytes.HasPrefix(sample, []byte{0xFE, 0xFF}):
+		return "utf-16be", true, decodeUTF16(sample[2:], true)
+	}
+
+	// ASCII text in UTF-16 has a zero in every other byte.
+	var evenZeros, oddZeros int
+	for i, b := range sample {
+		if b == 0 {
+			if i%2 == 0 {
+				evenZeros++
+			} else {
+				oddZeros++
+			}
+		}
+	}
+	if half := len(sample) / 2; half > 0 {
+		switch {
+		case oddZeros > half*3/4 && evenZeros == 0:
+			return "utf-16le", false, decodeUTF16(sample, false)
+		case evenZeros > half*3/4 && oddZeros == 0:
+			return "utf-16be", false, decodeUTF16(sample, true)
+		}
+	}
+
+	if utf8.Valid(trimPartialRune(sample)) {
+		return "utf-8", false, sample
+	}
+	// Bytes 0x80-0x9F are control characters in ISO-8859-1 but letters and
+	// punctuation in Windows-1252.
+	for _, b := range sample {
+		if b >= 0x80 && b <= 0x9F {
+			return "windows-1252", false, sample
+		}
+	}
+	return "iso-8859-1", false, sample
+}
+
+func decodeUTF16(data []byte, bigEndian bool) []byte {
+	units := make([]uint16, len(data)/2)
+	for i := range units {
+		if bigEndian {
+			units[i] = uint16(data[2*i])<<8 | uint16(data[2*i+1])
+		} else {
+			units[i] = uint16(data[2*i+1])<<8 | uint16(data[2*i])
+		}
+	}
+	return []byte(string(utf16.Decode(units)))
+}
+
+// trimPartialRune drops a multi-byte character cut off at the end of a sample.
+func trimPartialRune(sample []byte) []byte {
+	for i := len(sample) - 1; i >= 0 && i >= len(sample)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(sample[i]) {
+			if !utf8.FullRune(sample[i:]) {
+				return sample[:i]
+			}
+			break
+		}
+	}
+	return sample
+}
+
+// detectLineEnding returns the most common of "\r\n", "\n" and a lone "\r".
+func detectLineEnding(body []byte) string {
+	crlf := bytes.Count(body, []byte("\r\n"))
+	lf := bytes.Count(body, []byte("\n")) - crlf
+	cr := bytes.Count(body, []byte("\r")) - crlf
+	switch {
+	case crlf > 0 && crlf >= lf && crlf >= cr:
+		return "\r\n"
+	case cr > lf:
+		return "\r"
+	default:
+		return "\n"
+	}
+}
+
+// sampleLines splits a sample into its complete, non-empty lines. The last
+// line is dropped when the sample ends in the middle of it.
+func sampleLines(sample []byte, lineEnding string) [][]byte {
+	sep := []byte("\n")
+	if lineEnding == "\r" {
+		sep = []byte("\r")
+	}
+	lines := bytes.Split(sample, sep)
+	if len(lines) > 1 && !bytes.HasSuffix(sample, sep) {
+		lines = lines[:len(lines)-1]
+	}
+	var out [][]byte
+	for _, line := range lines {
+		line = bytes.TrimRight(line, "\r")
+		if len(line) == 0 {
+			continue
+		}
+		out = append(out, line)
+		if len(out) == sniffLines {
+			break
+		}
+	}
+	return out
+}
+
+// detectQuote counts the fields each candidate encloses: a quote at the
+// start of a field whose closing quote ends the field.
+func detectQuote(lines [][]byte) byte {
+	best, bestScore := quoteCandidates[0], 0
+	for _, quote := range quoteCandidates {
+		score := 0
+		for _, line := range lines {
+			score += countQuotedFields(line, quote)
+		}
+		if score > bestScore {
+			best, bestScore = quote, score
+		}
+	}
+	return best
+}
+
+func countQuotedFields(line []byte, quote byte) int {
+	count := 0
+	for i := 0; i < len(line); i++ {
+		if line[i] != quote || (i > 0 && !isDelimiterCandidate(line[i-1])) {
+			continue
+		}
+		j := i + 1
+		for j < len(line) {
+			if line[j] == quote {
+				if j+1 < len(line) && line[j+1] == quote {
+					j += 2
+					continue
+				}
+				break
+			}
+			j++
+		}
+		if j < len(line) && (j+1 == len(line) || isDelimiterCandidate(line[j+1])) {
+			count++
+			i = j
+		}
+	}
+	return count
+}
+
+func isDelimiterCandidate(b byte) bool {
+	return bytes.IndexByte(delimiterCandidates, b) >= 0
+}
+
+// detectDelimiter picks the separator that occurs the same number of times on
+// every line; otherwise the most frequent one. It falls back to a comma.
+func detectDelimiter(lines [][]byte, quote byte) byte {
+	// Consistent separators always beat inconsistent ones, which can at
+	// most score the length of the sample.
+	bonus := 1
+	for _, line := range lines {
+		bonus += len(line)
+	}
+
+	best, bestScore := byte(','), 0
+	for _, candidate := range delimiterCandidates {
+		first := countOutsideQuotes(lines[0], candidate, quote)
+		if first == 0 {
+			continue
+		}
+		consistent := true
+		total := 0
+		for _, line := range lines {
+			n := countOutsideQuotes(line, candidate, quote)
+			if n != first {
+				consistent = false
+			}
+			total += n
+		}
+		score := total
+		if consistent {
+			score += bonus
+		}
+		if score > bestScore {
+			best, bestScore = candidate, score
+		}
+	}
+	return best
+}
+
+func countOutsideQuotes(line []byte, delimiter, quote byte) int {
+	count := 0
+	inQuotes := false
+	for _, ch := range line {
+		switch {
+		case ch == quote:
+			inQuotes = !inQuotes
+		case ch == delimiter && !inQuotes:
+			count++
+		}
+	}
+	return count
+}
+
+// detectHeader treats the first record as a header unless one of its fields
+// is empty, repeated, or looks like a value (a number, boolean or date)
+// rather than a column name.
+func detectHeader(body []byte, format FileFormat) bool {
+	// Only parse complete lines, the sample may end in the middle of one.
+	if end := bytes.LastIndex(body, []byte(format.LineEnding)); end >= 0 {
+		body = body[:end+len(format.LineEnding)]
+	}
+	// UTF-16 samples have been decoded already.
+	format.HasHeader = true
+	format.Encoding = "utf-8"
+	header, err := NewCSVReader(bytes.NewReader(body), format).Header()
+	if err != nil && err != io.EOF {
+		return true
+	}
+
+	seen := make(map[string]bool, len(header))
+	for _, field := range header {
+		field = strings.TrimSpace(field)
+		if field == "" || seen[field] || looksLikeValue(field) {
+			return false
+		}
+		seen[field] = true
+	}
+	return true
+}
+
+var valueDateLayouts = []string{"2006-01-02", "2006-01-02 15:04:05", time.RFC3339, "01/02/2006", "02/01/2006"}
+
+func looksLikeValue(field string) bool {
+	if _, err := strconv.ParseFloat(field, 64); err == nil {
+		return true
+	}
+	if _, err := strconv.ParseBool(field); err == nil && len(field) > 1 {
+		return true
+	}
+	for _, layout := range valueDateLayouts {
+		if _, err := time.Parse(layout, field); err == nil {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,102 @@
+package services
+
+import (
+	"encoding/binary"
+	"testing"
+	"unicode/utf16"
+)
+
+// csvFormat returns DefaultFileFormat changed by edit.
+func csvFormat(edit func(f *FileFormat)) FileFormat {
+	f := DefaultFileFormat
+	if edit != nil {
+		edit(&f)
+	}
+	return f
+}
+
+func utf16Bytes(s string, bigEndian bool) []byte {
+	units := utf16.Encode([]rune(s))
+	out := make([]byte, 2*len(units))
+	for i, u := range units {
+		if bigEndian {
+			binary.BigEndian.PutUint16(out[2*i:], u)
+		} else {
+			binary.LittleEndian.PutUint16(out[2*i:], u)
+		}
+	}
+	return out
+}
+
+func TestDetectFormat(t *testing.T) {
+	tests := []struct {
+		name   string
+		sample []byte
+		want   FileFormat
+	}{
+		{"empty", nil, DefaultFileFormat},
+		{"comma with header", []byte("id,name\n1,a\n2,b\n"), DefaultFileFormat},
+		{"semicolon crlf", []byte("id;name\r\n1;a\r\n2;b\r\n"), csvFormat(func(f *FileFormat) {
+			f.Delimiter, f.LineEnding = ";", "\r\n"
+		})},
+		{"carriage returns", []byte("id,name\r1,a\r2,b\r"), csvFormat(func(f *FileFormat) { f.LineEnding = "\r" })},
+		{"tab without header", []byte("1\tx\n2\ty\n"), csvFormat(func(f *FileFormat) {
+			f.Delimiter, f.HasHeader = "\t", false
+		})},
+		{"pipe with quoted commas", []byte("name|note\n\"a\"|\"x, y, z\"\n\"b\"|\"p, q\"\n"), csvFormat(func(f *FileFormat) { f.Delimiter = "|" })},
+		{"single quotes", []byte("id,name\n1,'a,b'\n2,'c'\n"), csvFormat(func(f *FileFormat) { f.Quote = "'" })},
+		{"inconsistent separator loses", []byte("a,b,c\n1,2;3,4\n5,6,7\n"), DefaultFileFormat},
+		{"partial last line ignored", []byte("a,b\n1,2\n3;4;5;6"), DefaultFileFormat},
+		{"repeated header field", []byte("x,x\n1,2\n"), csvFormat(func(f *FileFormat) { f.HasHeader = false })},
+		{"empty header field", []byte("a,,b\n1,2,3\n"), csvFormat(func(f *FileFormat) { f.HasHeader = false })},
+		{"numeric first row", []byte("1.5,-2\n3,4\n"), csvFormat(func(f *FileFormat) { f.HasHeader = false })},
+		{"date first row", []byte("2026-01-02,x\n2026-01-03,y\n"), csvFormat(func(f *FileFormat) { f.HasHeader = false })},
+		{"boolean first row", []byte("true,x\nfalse,y\n"), csvFormat(func(f *FileFormat) { f.HasHeader = false })},
+		{"utf-8 bom", []byte("\xEF\xBB\xBFid,name\n1,a\n"), csvFormat(func(f *FileFormat) { f.BOM = true })},
+		{"utf-16le bom", append([]byte{0xFF, 0xFE}, utf16Bytes("id;name\n1;a\n", false)...), csvFormat(func(f *FileFormat) {
+			f.Delimiter, f.Encoding, f.BOM = ";", "utf-16le", true
+		})},
+		{"utf-16be bom", append([]byte{0xFE, 0xFF}, utf16Bytes("id;name\n1;a\n", true)...), csvFormat(func(f *FileFormat) {
+			f.Delimiter, f.Encoding, f.BOM = ";", "utf-16be", true
+		})},
+		{"utf-16le without bom", utf16Bytes("id,name\n1,a\n", false), csvFormat(func(f *FileFormat) { f.Encoding = "utf-16le" })},
+		{"utf-16be without bom", utf16Bytes("id,name\n1,a\n", true), csvFormat(func(f *FileFormat) { f.Encoding = "utf-16be" })},
+		{"utf-8 cut mid character", []byte("name\ncaf\xC3"), DefaultFileFormat},
+		{"latin1", []byte("name\ncaf\xE9\n"), csvFormat(func(f *FileFormat) { f.Encoding = "iso-8859-1" })},
+		{"windows-1252", []byte("name\n\x93quoted\x94\n"), csvFormat(func(f *FileFormat) { f.Encoding = "windows-1252" })},
+		{"parquet", []byte("PAR1\x15\x04"), FileFormat{Type: FileTypeParquet}},
+		{"ndjson", []byte("{\"id\":1}\n{\"id\":2}\n"), FileFormat{Type: FileTypeNDJSON}},
+		{"brace in csv", []byte("{id},name\n1,a\n"), DefaultFileFormat},
+	}
+	for _, tt := range tests {
+		if got := DetectFormat(tt.sample); got != tt.want {
+			t.Errorf("%s: DetectFormat = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFileFormatValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		format FileFormat
+		ok     bool
+	}{
+		{"default", DefaultFileFormat, true},
+		{"no type means csv", csvFormat(func(f *FileFormat) { f.Type = "" }), true},
+		{"unicode delimiter", csvFormat(func(f *FileFormat) { f.Delimiter = "¦" }), true},
+		{"no quote", csvFormat(func(f *FileFormat) { f.Quote = "" }), true},
+		{"unknown type", csvFormat(func(f *FileFormat) { f.Type = "xml" }), false},
+		{"long delimiter", csvFormat(func(f *FileFormat) { f.Delimiter = "::" }), false},
+		{"newline delimiter", csvFormat(func(f *FileFormat) { f.Delimiter = "\n" }), false},
+		{"quote equals delimiter", csvFormat(func(f *FileFormat) { f.Quote = "," }), false},
+		{"bad line ending", csvFormat(func(f *FileFormat) { f.LineEnding = "\n\r" }), false},
+		{"bad encoding", csvFormat(func(f *FileFormat) { f.Encoding = "ebcdic" }), false},
+		{"unknown compression", csvFormat(func(f *FileFormat) { f.Compression = "rar" }), false},
+		{"compressed parquet", FileFormat{Type: FileTypeParquet, Compression: "gzip"}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.format.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: Validate = %v, want ok %v", tt.name, err, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+)
+
+// stagingDropTimeout bounds dropping a staging table after the import's own
+// context may already have been canceled.
+const stagingDropTimeout = 30 * time.Second
+
+// TableInfo is what system.tables reports about a table.
+type TableInfo struct {
+	Engine       string
+	EngineFull   string // engine with its arguments and clauses
+	PartitionKey string
+	SortingKey   string
+}
+
+// MergeTree reports whether the table belongs to the MergeTree family,
+// including its replicated variants.
+func (t TableInfo) MergeTree() bool {
+	return strings.HasSuffix(t.Engine, "MergeTree")
+}
+
+// Replicated reports whether the table uses a Replicated* engine, whose
+// ZooKeeper path and replica name a copy of the table cannot share.
+func (t TableInfo) Replicated() bool {
+	return strings.HasPrefix(t.Engine, "Replicated")
+}
+
+// DeduplicatesRows reports whether merges may change the number of rows,
+// as with ReplacingMergeTree or CollapsingMergeTree.
+func (t TableInfo) DeduplicatesRows() bool {
+	for _, kind := range []string{"Replacing", "Summing", "Aggregating", "Collapsing"} {
+		if strings.Contains(t.Engine, kind) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetTableInfo reads the engine and keys of database.table.
+func GetTableInfo(ctx context.Context, conn driver.Conn, database, table string) (*TableInfo, error) {
+	var info TableInfo
+	row := conn.QueryRow(ctx, "SELECT engine, engine_full, partition_key, sorting_key FROM system.tables WHERE database = ? AND name = ?", database, table)
+	if err := row.Scan(&info.Engine, &info.EngineFull, &info.PartitionKey, &info.SortingKey); err != nil {
+		return nil, fmt.Errorf("failed to read table %s.%s: %v", database, table, err)
+	}
+	return &info, nil
+}
+
+// StagingTable is an empty copy of a target table that an import loads
+// into, so no rows reach the target until all of them have been loaded.
+type StagingTable struct {
+	conn     driver.Conn
+	database string
+	name     string
+	target   string
+}
+
+// CreateStagingTable creates a staging table with the structure and engine
+// of database.target. suffix makes its name unique, e.g. a job ID.
+func CreateStagingTable(ctx context.Context, conn driver.Conn, database, target, suffix string) (*StagingTable, error) {
+	suffix = strings.ReplaceAll(suffix, "-", "")
+	if len(suffix) > 12 {
+		suffix = suffix[:12]
+	}
+	s := &StagingTable{conn: conn, database: database, name: target + "_staging_" + suffix, target: target}
+	query := fmt.Sprintf("CREATE TABLE %s AS %s", s.Table(), QualifiedTable(database, target))
+	if err := conn.Exec(ctx, query); err != nil {
+		return nil, fmt.Errorf("failed to create staging table: %v", err)
+	}
+	return s, nil
+}
+
+// Table returns the quoted name of the staging table.
+func (s *StagingTable) Table() string {
+	return QualifiedTable(s.database, s.name)
+}
+
+// Publish checks that the staging table holds rows rows, unless the engine
+// may have merged some away, and moves them into the target as plan says:
+// a truncate exchanges the staging table and the target, replacePartitions
+// replaces the partitions the staging table has rows for, and an append or
+// upsert attaches the staged partitions to it. An append never exchanges an
+// empty target, since rows inserted by others in the meantime would be
+// dropped with the staging table. A collapsing upsert first cancels the rows
+// the staged ones replace.
+func (s *StagingTable) Publish(ctx context.Context, plan *WritePlan, rows uint64) error {
+	info := plan.Table
+	if !info.DeduplicatesRows() {
+		var staged uint64
+		if err := s.conn.QueryRow(ctx, "SELECT count() FROM "+s.Table()).Scan(&staged); err != nil {
+			return fmt.Errorf("failed to count staged rows: %v", err)
+		}
+		if staged != rows {
+			return fmt.Errorf("staging table holds %d rows, expected %d", staged, rows)
+		}
+	}
+
+	switch plan.Mode {
+	case WriteTruncate:
+		return s.Exchange(ctx)
+	case WriteReplacePartitions:
+		return s.ReplacePartitions(ctx)
+	}
+	if plan.Sign != "" {
+		if err := s.CancelRows(ctx, info.SortingKey, plan.Sign); err != nil {
+			return err
+		}
+	}
+	return s.AttachPartitions(ctx)
+}
+
+// Exchange swaps the staging table and the target, leaving the previous
+// contents of the target in the staging table.
+func (s *StagingTable) Exchange(ctx context.Context) error {
+	query := fmt.Sprintf("EXCHANGE TABLES %s AND %s", s.Table(), QualifiedTable(s.database, s.target))
+	if err := s.conn.Exec(ctx, query); err != nil {
+		return fmt.Errorf("failed to exchange staging table: %v", err)
+	}
+	return nil
+}
+
+// PartialPublishError is returned when moving the staged partitions into
+// the target failed after some of them had already been moved. ClickHouse
+// moves each partition on its own, so those stay in the target.
+type PartialPublishError struct {
+	Published []string // IDs of the partitions already in the target
+	Partition string   // ID of the partition that failed
+	Err       error
+}
+
+func (e *PartialPublishError) Error() string {
+	return fmt.Sprintf("partial publish: partitions %s are in the target, but %v", strings.Join(e.Published, ", "), e.Err)
+}
+
+func (e *PartialPublishError) Unwrap() error { return e.Err }
+
+// AttachPartitions copies every partition of the staging table into the
+// target, next to the rows already there.
+func (s *StagingTable) AttachPartitions(ctx context.Context) error {
+	return s.movePartitions(ctx, "ATTACH")
+}
+
+// ReplacePartitions replaces each partition of the target that the staging
+// table has rows for with the staged one. Other partitions are kept.
+func (s *StagingTable) ReplacePartitions(ctx context.Context) error {
+	return s.movePartitions(ctx, "REPLACE")
+}
+
+// movePartitions runs ALTER TABLE ... <action> PARTITION ... FROM for each
+// staged partition. If one fails, the target is unchanged when it was the
+// first; otherwise a *PartialPublishError names those already moved.
+func (s *StagingTable) movePartitions(ctx context.Context, action string) error {
+	partitions, err := s.partitions(ctx)
+	if err != nil {
+		return err
+	}
+	for i, id := range partitions {
+		query := fmt.Sprintf("ALTER TABLE %s %s PARTITION ID %s FROM %s", QualifiedTable(s.database, s.target), action, QuoteString(id), s.Table())
+		if err := s.conn.Exec(ctx, query); err != nil {
+			err = fmt.Errorf("failed to %s partition %s: %v", strings.ToLower(action), id, err)
+			if i == 0 {
+				return err
+			}
+			return &PartialPublishError{Published: partitions[:i], Partition: id, Err: err}
+		}
+	}
+	return nil
+}
+
+// CancelRows inserts a cancel row (sign -1) into the staging table for the
+// current state of every target row whose sorting key also occurs in it, so
+// the cancel rows reach the target together with the new rows and a
+// CollapsingMergeTree drops the old rows only once they are replaced.
+// Merges of the staging table are stopped first, as they would collapse a
+// cancel row with the new row of the same key.
+func (s *StagingTable) CancelRows(ctx context.Context, sortingKey, sign string) error {
+	if err := s.conn.Exec(ctx, "SYSTEM STOP MERGES "+s.Table()); err != nil {
+		return fmt.Errorf("failed to stop merges of the staging table: %v", err)
+	}
+	rows, err := s.conn.Query(ctx, "SELECT name FROM system.columns WHERE database = ? AND table = ? AND default_kind NOT IN ('MATERIALIZED', 'ALIAS', 'EPHEMERAL') ORDER BY position", s.database, s.target)
+	if err != nil {
+		return fmt.Errorf("failed to read target columns: %v", err)
+	}
+	var columns, selected []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to read target columns: %v", err)
+		}
+		columns = append(columns, name)
+		if name == sign {
+			selected = append(selected, "-1")
+		} else {
+			selected = append(selected, QuoteIdentifier(name))
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read target columns: %v", err)
+	}
+
+	target := QualifiedTable(s.database, s.target)
+	query := fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM %s FINAL WHERE %s = 1 AND (%s) IN (SELECT %s FROM %s)",
+		s.Table(), QuoteIdentifiers(columns), strings.Join(selected, ", "), target,
+		QuoteIdentifier(sign), sortingKey, sortingKey, s.Table())
+	if err := s.conn.Exec(ctx, query); err != nil {
+		return fmt.Errorf("failed to cancel replaced rows: %v", err)
+	}
+	return nil
+}
+
+func (s *StagingTable) partitions(ctx context.Context) ([]string, error) {
+	rows, err := s.conn.Query(ctx, "SELECT DISTINCT partition_id FROM system.parts WHERE database = ? AND table = ? AND active ORDER BY partition_id", s.database, s.name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list staged partitions: %v", err)
+	}
+	defer rows.Close()
+	var partitions []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to list staged partitions: %v", err)
+		}
+		partitions = append(partitions, id)
+	}
+	return partitions, rows.Err()
+}
+
+// Drop removes the staging table. It runs on its own context so it also
+// cleans up after a canceled import.
+func (s *StagingTable) Drop() {
+	ctx, cancel := context.WithTimeout(context.Background(), stagingDropTimeout)
+	defer cancel()
+	if err := s.conn.Exec(ctx, "DROP TABLE IF EXISTS "+s.Table()); err != nil {
+		log.Printf("Failed to drop staging table %s: %v", s.Table(), err)
+	}
+}
@@ -0,0 +1,247 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/AditiKulkarni9/clickhouse-flatfile-tool/utils"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	// DefaultAccessTokenTTL is how long an access token is valid.
+	DefaultAccessTokenTTL = time.Hour
+	// DefaultRefreshTokenTTL is how long a refresh token is valid.
+	DefaultRefreshTokenTTL = 7 * 24 * time.Hour
+)
+
+var (
+	ErrTokenRevoked = errors.New("token has been revoked")
+	ErrUnknownUser  = errors.New("user no longer exists")
+	ErrNotOwnToken  = errors.New("token belongs to another user")
+)
+
+// TokenPair is the response to a login or refresh.
+type TokenPair struct {
+	AccessToken      string `json:"accessToken"`
+	RefreshToken     string `json:"refreshToken"`
+	TokenType        string `json:"tokenType"`
+	ExpiresIn        int64  `json:"expiresIn"`
+	RefreshExpiresIn int64  `json:"refreshExpiresIn"`
+}
+
+// TokenService issues access and refresh tokens to authenticated users and
+// keeps the list of revoked tokens. Refresh tokens are single use: every
+// refresh revokes the token it was given.
+type TokenService struct {
+	auth       Authenticator
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+
+	mu          sync.Mutex
+	revoked     map[string]time.Time // jti -> token expiry
+	revokedPath string               // file the revocations are kept in, if any
+}
+
+// NewTokenService creates a token service. Non-positive lifetimes fall back to
+// DefaultAccessTokenTTL and DefaultRefreshTokenTTL.
+func NewTokenService(auth Authenticator, accessTTL, refreshTTL time.Duration) *TokenService {
+	if accessTTL <= 0 {
+		accessTTL = DefaultAccessTokenTTL
+	}
+	if refreshTTL <= 0 {
+		refreshTTL = DefaultRefreshTokenTTL
+	}
+	return &TokenService{
+		auth:       auth,
+		accessTTL:  accessTTL,
+		refreshTTL: refreshTTL,
+		revoked:    make(map[string]time.Time),
+	}
+}
+
+// PersistRevocations keeps the revoked tokens in the JSON file at path, so
+// they stay revoked across restarts. Revocations already in the file are
+// loaded; a missing file is created on the first revocation.
+func (s *TokenService) PersistRevocations(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to read revoked tokens: %v", err)
+	}
+	if err == nil {
+		var revoked map[string]time.Time
+		if err := json.Unmarshal(data, &revoked); err != nil {
+			return fmt.Errorf("failed to parse revoked tokens: %v", err)
+		}
+		for jti, expiry := range revoked {
+			s.revoked[jti] = expiry
+		}
+	}
+	s.revokedPath = path
+	return nil
+}
+
+// Login checks the credentials and issues a token pair.
+func (s *TokenService) Login(username, password string) (*TokenPair, error) {
+	if err := s.auth.Authenticate(username, password); err != nil {
+		return nil, err
+	}
+	return s.issue(username)
+}
+
+// Refresh exchanges a refresh token for a new token pair, if its user still
+// exists. The token is revoked in the same step as it is checked, so of two
+// refreshes with one token only the first succeeds.
+func (s *TokenService) Refresh(refreshToken string) (*TokenPair, error) {
+	claims, err := s.parse(refreshToken, utils.RefreshToken)
+	if err != nil {
+		return nil, err
+	}
+	if !s.revoke(claims) {
+		return nil, ErrTokenRevoked
+	}
+	user, _ := claims["user"].(string)
+	exists, err := s.auth.UserExists(user)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrUnknownUser
+	}
+	return s.issue(user)
+}
+
+// Validate checks an access token and returns its claims.
+func (s *TokenService) Validate(accessToken string) (jwt.MapClaims, error) {
+	return s.parse(accessToken, utils.AccessToken)
+}
+
+// Revoke revokes a token of either type. Tokens that are already invalid are ignored.
+func (s *TokenService) Revoke(token string) {
+	if claims, err := utils.ValidateToken(token); err == nil {
+		s.RevokeClaims(claims)
+	}
+}
+
+// RevokeOwn revokes a token of either type if it was issued to user, and
+// returns ErrNotOwnToken otherwise. Tokens that are already invalid are
+// ignored.
+func (s *TokenService) RevokeOwn(user, token string) error {
+	claims, err := utils.ValidateToken(token)
+	if err != nil {
+		return nil
+	}
+	if owner, _ := claims["user"].(string); owner != user {
+		return ErrNotOwnToken
+	}
+	s.RevokeClaims(claims)
+	return nil
+}
+
+// RevokeClaims revokes the token the claims belong to until it expires.
+func (s *TokenService) RevokeClaims(claims jwt.MapClaims) {
+	s.revoke(claims)
+}
+
+// revoke revokes the token the claims belong to and reports whether it was
+// still valid, that is, whether this call revoked it.
+func (s *TokenService) revoke(claims jwt.MapClaims) bool {
+	id, _ := claims["jti"].(string)
+	exp, _ := claims["exp"].(float64)
+	if id == "" {
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for jti, expiry := range s.revoked {
+		if expiry.Before(now) {
+			delete(s.revoked, jti)
+		}
+	}
+	if _, revoked := s.revoked[id]; revoked {
+		return false
+	}
+	s.revoked[id] = time.Unix(int64(exp), 0)
+	if s.revokedPath != "" {
+		// The token stays revoked in memory; only a restart would forget it.
+		if err := s.saveRevoked(); err != nil {
+			log.Printf("Failed to persist token revocation: %v", err)
+		}
+	}
+	return true
+}
+
+// saveRevoked writes the revoked tokens to a temporary file and renames it
+// over revokedPath. The caller must hold the lock.
+func (s *TokenService) saveRevoked() error {
+	data, err := json.Marshal(s.revoked)
+	if err != nil {
+		return fmt.Errorf("failed to encode revoked tokens: %v", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.revokedPath), ".revoked-*")
+	if err != nil {
+		return fmt.Errorf("failed to write revoked tokens: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write revoked tokens: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write revoked tokens: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), s.revokedPath); err != nil {
+		return fmt.Errorf("failed to write revoked tokens: %v", err)
+	}
+	return nil
+}
+
+func (s *TokenService) issue(username string) (*TokenPair, error) {
+	access, err := utils.GenerateToken(username, utils.AccessToken, s.accessTTL)
+	if err != nil {
+		return nil, err
+	}
+	refresh, err := utils.GenerateToken(username, utils.RefreshToken, s.refreshTTL)
+	if err != nil {
+		return nil, err
+	}
+	return &TokenPair{
+		AccessToken:      access,
+		RefreshToken:     refresh,
+		TokenType:        "Bearer",
+		ExpiresIn:        int64(s.accessTTL.Seconds()),
+		RefreshExpiresIn: int64(s.refreshTTL.Seconds()),
+	}, nil
+}
+
+func (s *TokenService) parse(token, tokenType string) (jwt.MapClaims, error) {
+	claims, err := utils.ValidateToken(token)
+	if err != nil {
+		return nil, err
+	}
+	if typ, _ := claims["typ"].(string); typ != tokenType {
+		return nil, fmt.Errorf("expected %s token", tokenType)
+	}
+	if user, _ := claims["user"].(string); user == "" {
+		return nil, fmt.Errorf("token has no user claim")
+	}
+
+	id, _ := claims["jti"].(string)
+	s.mu.Lock()
+	_, revoked := s.revoked[id]
+	s.mu.Unlock()
+	if revoked {
+		return nil, ErrTokenRevoked
+	}
+	return claims, nil
+}
@@ -0,0 +1,294 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// DefaultUploadsDir is where uploads are kept when UPLOADS_DIR is unset.
+	DefaultUploadsDir = "uploads"
+	// DefaultUploadRetention is how long uploads are kept before they are purged.
+	DefaultUploadRetention = 7 * 24 * time.Hour
+	// uploadSniffBytes is how much of an upload is used to detect its format.
+	uploadSniffBytes = 64 << 10
+)
+
+var ErrUploadNotFound = errors.New("upload not found")
+
+// Upload describes an uploaded flat file.
+type Upload struct {
+	ID           string     `json:"id"`
+	Owner        string     `json:"-"`
+	OriginalName string     `json:"originalName"`
+	Size         int64      `json:"size"`
+	SHA256       string     `json:"sha256"`
+	Format       FileFormat `json:"format"`
+	Uploader     string     `json:"uploader"`
+	CreatedAt    time.Time  `json:"createdAt"`
+	ExpiresAt    time.Time  `json:"expiresAt"`
+}
+
+// uploadMeta is the metadata file contents. Owner is stored here but kept out
+// of API responses.
+type uploadMeta struct {
+	*Upload
+	Owner string `json:"owner"`
+}
+
+// UploadStore keeps uploaded files under generated IDs in a single directory,
+// each with a JSON metadata file, and purges uploads older than the retention
+// period.
+type UploadStore struct {
+	dir       string
+	retention time.Duration
+
+	mu    sync.Mutex
+	locks map[string]*uploadLock // serialises writes to each chunked upload
+}
+
+type uploadLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+// NewUploadStore creates the store, and its directory if needed, and starts
+// purging expired uploads.
+func NewUploadStore(dir string, retention time.Duration) (*UploadStore, error) {
+	if retention <= 0 {
+		retention = DefaultUploadRetention
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create uploads directory: %v", err)
+	}
+	s := &UploadStore{dir: dir, retention: retention, locks: make(map[string]*uploadLock)}
+	go s.purgeLoop()
+	return s, nil
+}
+
+// Save stores the contents of r as a new upload of owner. name is the
+// client's file name and is only kept as metadata.
+func (s *UploadStore) Save(owner, uploader, name string, r io.Reader) (*Upload, error) {
+	now := time.Now().UTC()
+	upload := &Upload{
+		ID:           uuid.NewString(),
+		Owner:        owner,
+		OriginalName: filepath.Base(strings.ReplaceAll(name, `\`, "/")),
+		Uploader:     uploader,
+		CreatedAt:    now,
+		ExpiresAt:    now.Add(s.retention),
+	}
+
+	file, err := os.Create(s.dataPath(upload.ID) + ".part")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create upload file: %v", err)
+	}
+	defer os.Remove(file.Name())
+
+	hash := sha256.New()
+	sample := &prefixBuffer{limit: uploadSniffBytes}
+	size, err := io.Copy(io.MultiWriter(file, hash, sample), r)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to save upload: %v", err)
+	}
+	upload.Size = size
+	upload.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	upload.Format = DetectFileFormat(upload.OriginalName, file.Name(), sample.buf)
+
+	if err := os.Rename(file.Name(), s.dataPath(upload.ID)); err != nil {
+		return nil, fmt.Errorf("failed to save upload: %v", err)
+	}
+	if err := s.writeMeta(upload); err != nil {
+		os.Remove(s.dataPath(upload.ID))
+		return nil, err
+	}
+	return upload, nil
+}
+
+func (s *UploadStore) writeMeta(upload *Upload) error {
+	meta, err := json.MarshalIndent(uploadMeta{upload, upload.Owner}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode upload metadata: %v", err)
+	}
+	if err := os.WriteFile(s.metaPath(upload.ID), meta, 0o644); err != nil {
+		return fmt.Errorf("failed to write upload metadata: %v", err)
+	}
+	return nil
+}
+
+// List returns the uploads of owner, newest first.
+func (s *UploadStore) List(owner string) ([]*Upload, error) {
+	uploads, err := s.all()
+	if err != nil {
+		return nil, err
+	}
+	owned := make([]*Upload, 0, len(uploads))
+	for _, upload := range uploads {
+		if upload.Owner == owner {
+			owned = append(owned, upload)
+		}
+	}
+	return owned, nil
+}
+
+func (s *UploadStore) all() ([]*Upload, error) {
+	paths, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	uploads := make([]*Upload, 0, len(paths))
+	for _, path := range paths {
+		upload, err := s.readMeta(strings.TrimSuffix(filepath.Base(path), ".json"))
+		if err != nil {
+			continue
+		}
+		uploads = append(uploads, upload)
+	}
+	sort.Slice(uploads, func(i, j int) bool { return uploads[i].CreatedAt.After(uploads[j].CreatedAt) })
+	return uploads, nil
+}
+
+// Get returns the metadata of an upload. Uploads of other owners are not found.
+func (s *UploadStore) Get(owner, id string) (*Upload, error) {
+	upload, err := s.readMeta(id)
+	if err != nil {
+		return nil, err
+	}
+	if upload.Owner != owner {
+		return nil, ErrUploadNotFound
+	}
+	return upload, nil
+}
+
+func (s *UploadStore) readMeta(id string) (*Upload, error) {
+	if uuid.Validate(id) != nil {
+		return nil, ErrUploadNotFound
+	}
+	data, err := os.ReadFile(s.metaPath(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrUploadNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read upload metadata: %v", err)
+	}
+	meta := uploadMeta{Upload: &Upload{}}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, fmt.Errorf("failed to parse upload metadata: %v", err)
+	}
+	meta.Upload.Owner = meta.Owner
+	return meta.Upload, nil
+}
+
+// Path returns an upload of owner together with the path of its file.
+func (s *UploadStore) Path(owner, id string) (*Upload, string, error) {
+	upload, err := s.Get(owner, id)
+	if err != nil {
+		return nil, "", err
+	}
+	return upload, s.dataPath(id), nil
+}
+
+// Delete removes an upload of owner. Jobs that already opened the file can
+// still finish reading it.
+func (s *UploadStore) Delete(owner, id string) error {
+	if _, err := s.Get(owner, id); err != nil {
+		return err
+	}
+	return s.remove(id)
+}
+
+func (s *UploadStore) remove(id string) error {
+	if err := os.Remove(s.metaPath(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete upload: %v", err)
+	}
+	if err := os.Remove(s.dataPath(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete upload: %v", err)
+	}
+	return nil
+}
+
+func (s *UploadStore) purgeLoop() {
+	interval := min(s.retention/4, time.Hour)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		s.purge(time.Now())
+	}
+}
+
+// lock takes the lock of one upload and returns the function releasing it.
+func (s *UploadStore) lock(id string) func() {
+	s.mu.Lock()
+	l, ok := s.locks[id]
+	if !ok {
+		l = &uploadLock{}
+		s.locks[id] = l
+	}
+	l.refs++
+	s.mu.Unlock()
+
+	l.mu.Lock()
+	return func() {
+		l.mu.Unlock()
+		s.mu.Lock()
+		if l.refs--; l.refs == 0 {
+			delete(s.locks, id)
+		}
+		s.mu.Unlock()
+	}
+}
+
+// purge deletes uploads, complete or partial, that expired before now.
+func (s *UploadStore) purge(now time.Time) {
+	s.purgePartial(now)
+
+	uploads, err := s.all()
+	if err != nil {
+		log.Printf("Failed to list uploads for purging: %v", err)
+		return
+	}
+	for _, upload := range uploads {
+		if upload.ExpiresAt.Before(now) {
+			if err := s.remove(upload.ID); err != nil {
+				log.Printf("Failed to purge upload %s: %v", upload.ID, err)
+			}
+		}
+	}
+}
+
+func (s *UploadStore) dataPath(id string) string {
+	return filepath.Join(s.dir, id+".data")
+}
+
+func (s *UploadStore) metaPath(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+// prefixBuffer keeps the first limit bytes written to it and discards the rest.
+type prefixBuffer struct {
+	buf   []byte
+	limit int
+}
+
+func (b *prefixBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - len(b.buf); room > 0 {
+		b.buf = append(b.buf, p[:min(room, len(p))]...)
+	}
+	return len(p), nil
+}
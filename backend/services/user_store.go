@@ -0,0 +1,164 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/AditiKulkarni9/clickhouse-flatfile-tool/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// LocalUserStore keeps users with bcrypt hashed passwords in a JSON file.
+// The file is re-read whenever it changes, so users added or removed by
+// another process are seen without a restart.
+type LocalUserStore struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	users   map[string]models.User
+}
+
+// NewLocalUserStore loads the users in path. A missing file is an empty store;
+// it is created on the first write.
+func NewLocalUserStore(path string) (*LocalUserStore, error) {
+	s := &LocalUserStore{path: path}
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Authenticate implements Authenticator.
+func (s *LocalUserStore) Authenticate(username, password string) error {
+	s.mu.Lock()
+	if err := s.reload(); err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	user, ok := s.users[username]
+	s.mu.Unlock()
+	if !ok {
+		return checkBcrypt(nil, password)
+	}
+	return checkBcrypt([]byte(user.PasswordHash), password)
+}
+
+// UserExists implements Authenticator.
+func (s *LocalUserStore) UserExists(username string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.reload(); err != nil {
+		return false, err
+	}
+	_, ok := s.users[username]
+	return ok, nil
+}
+
+// reload re-reads the file if its size or modification time changed. A
+// missing file holds no users. The caller must hold the lock, except during
+// construction.
+func (s *LocalUserStore) reload() error {
+	info, err := os.Stat(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		s.users, s.modTime, s.size = make(map[string]models.User), time.Time{}, 0
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read user store: %v", err)
+	}
+	if s.users != nil && info.ModTime().Equal(s.modTime) && info.Size() == s.size {
+		return nil
+	}
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return fmt.Errorf("failed to read user store: %v", err)
+	}
+	var users []models.User
+	if err := json.Unmarshal(data, &users); err != nil {
+		return fmt.Errorf("failed to parse user store: %v", err)
+	}
+	s.users = make(map[string]models.User, len(users))
+	for _, user := range users {
+		s.users[user.Username] = user
+	}
+	s.modTime, s.size = info.ModTime(), info.Size()
+	return nil
+}
+
+// SetPassword creates the user or changes their password.
+func (s *LocalUserStore) SetPassword(username, password string) error {
+	if username == "" || password == "" {
+		return fmt.Errorf("username and password are required")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %v", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.reload(); err != nil {
+		return err
+	}
+	user, ok := s.users[username]
+	if !ok {
+		user = models.User{Username: username, CreatedAt: time.Now().UTC()}
+	}
+	user.PasswordHash = string(hash)
+	s.users[username] = user
+	return s.save()
+}
+
+// DeleteUser removes a user.
+func (s *LocalUserStore) DeleteUser(username string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.reload(); err != nil {
+		return err
+	}
+	if _, ok := s.users[username]; !ok {
+		return fmt.Errorf("user %q not found", username)
+	}
+	delete(s.users, username)
+	return s.save()
+}
+
+// save writes the store through a temporary file so a crash never leaves a
+// truncated file behind. The caller must hold the lock.
+func (s *LocalUserStore) save() error {
+	users := make([]models.User, 0, len(s.users))
+	for _, user := range s.users {
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Username < users[j].Username })
+
+	data, err := json.MarshalIndent(users, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode user store: %v", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".users-*")
+	if err != nil {
+		return fmt.Errorf("failed to write user store: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write user store: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write user store: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to write user store: %v", err)
+	}
+	return nil
+}
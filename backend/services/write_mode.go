@@ -0,0 +1,211 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AditiKulkarni9/clickhouse-flatfile-tool/chtypes"
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+)
+
+// WriteMode is what an import does with the rows already in its target.
+type WriteMode string
+
+const (
+	// WriteAppend adds the rows next to the existing ones.
+	WriteAppend WriteMode = "append"
+	// WriteTruncate empties the target before loading it.
+	WriteTruncate WriteMode = "truncate"
+	// WriteReplacePartitions replaces the partitions the file has rows for
+	// and leaves the others alone.
+	WriteReplacePartitions WriteMode = "replacePartitions"
+	// WriteUpsert replaces the rows with the same sorting key through the
+	// engine of a ReplacingMergeTree or CollapsingMergeTree target.
+	WriteUpsert WriteMode = "upsert"
+)
+
+// ParseWriteMode validates a write mode; an empty one means WriteAppend.
+func ParseWriteMode(s string) (WriteMode, error) {
+	switch mode := WriteMode(s); mode {
+	case "":
+		return WriteAppend, nil
+	case WriteAppend, WriteTruncate, WriteReplacePartitions, WriteUpsert:
+		return mode, nil
+	}
+	return "", fmt.Errorf("unknown write mode %q: expected append, truncate, replacePartitions or upsert", s)
+}
+
+// WritePlan is how an import writes to its target in a given mode.
+type WritePlan struct {
+	Mode  WriteMode
+	Table TableInfo
+	// Sign is the sign column of a CollapsingMergeTree upsert.
+	Sign string
+	// Version is the version column of an upsert, if the engine has one.
+	Version string
+}
+
+// PlanWrite checks that database.table supports mode. versionColumn, if
+// set, must be the version column the upsert engine was created with.
+func PlanWrite(ctx context.Context, conn driver.Conn, database, table string, mode WriteMode, versionColumn string) (*WritePlan, error) {
+	info, err := GetTableInfo(ctx, conn, database, table)
+	if err != nil {
+		return nil, err
+	}
+	plan := &WritePlan{Mode: mode, Table: *info}
+	if versionColumn != "" && mode != WriteUpsert {
+		return nil, fmt.Errorf("versionColumn only applies to upsert")
+	}
+
+	switch mode {
+	case WriteReplacePartitions:
+		if !info.MergeTree() {
+			return nil, fmt.Errorf("cannot replace partitions of a %s table", info.Engine)
+		}
+		if info.PartitionKey == "" {
+			return nil, fmt.Errorf("table %s.%s is not partitioned; use truncate to replace all of it", database, table)
+		}
+	case WriteUpsert:
+		args := info.EngineArgs()
+		switch {
+		case strings.Contains(info.Engine, "VersionedCollapsingMergeTree"):
+			if len(args) < 2 {
+				return nil, fmt.Errorf("cannot read the sign and version columns of %s", info.Engine)
+			}
+			plan.Sign, plan.Version = args[0], args[1]
+		case strings.Contains(info.Engine, "CollapsingMergeTree"):
+			if len(args) < 1 {
+				return nil, fmt.Errorf("cannot read the sign column of %s", info.Engine)
+			}
+			plan.Sign = args[0]
+		case strings.Contains(info.Engine, "ReplacingMergeTree"):
+			if len(args) > 0 {
+				plan.Version = args[0]
+			}
+		default:
+			return nil, fmt.Errorf("upsert needs a ReplacingMergeTree or CollapsingMergeTree table, not %s", info.Engine)
+		}
+		if plan.Sign != "" && info.SortingKey == "" {
+			return nil, fmt.Errorf("table %s.%s has no sorting key to match rows on", database, table)
+		}
+		if versionColumn != "" && versionColumn != plan.Version {
+			if plan.Version == "" {
+				return nil, fmt.Errorf("%s table %s.%s has no version column; the last row inserted wins", info.Engine, database, table)
+			}
+			return nil, fmt.Errorf("version column of %s.%s is %s, not %s", database, table, plan.Version, versionColumn)
+		}
+	}
+	return plan, nil
+}
+
+// Staged reports whether the import must load into a staging table first:
+// always when atomic, and for modes that compare the new rows with the
+// existing ones. Replicated targets cannot be staged, as a staging table
+// created AS them would claim the same replica in ZooKeeper, and staged rows
+// are only added to MergeTree targets, by attaching their partitions.
+func (p *WritePlan) Staged(atomic bool) (bool, error) {
+	if !atomic && p.Mode != WriteReplacePartitions && p.Sign == "" {
+		return false, nil
+	}
+	if p.Table.Replicated() {
+		return false, fmt.Errorf("cannot stage an import into a %s table; atomic, replacePartitions and collapsing upsert imports need a non-replicated target", p.Table.Engine)
+	}
+	if p.Mode != WriteTruncate && !p.Table.MergeTree() {
+		return false, fmt.Errorf("cannot add rows atomically to a %s table; use truncate or a MergeTree table", p.Table.Engine)
+	}
+	return true, nil
+}
+
+// Defaults returns the upsert columns the import does not load from the
+// file, with the values to fill them with: 1 for the sign column and the
+// time of the import for the version column.
+func (p *WritePlan) Defaults(columns []string, columnTypes map[string]*chtypes.Type, now time.Time) ([]string, []interface{}, error) {
+	loaded := make(map[string]bool, len(columns))
+	for _, col := range columns {
+		loaded[col] = true
+	}
+	var names []string
+	var values []interface{}
+	if p.Sign != "" && !loaded[p.Sign] {
+		names = append(names, p.Sign)
+		values = append(values, int8(1))
+	}
+	if p.Version != "" && !loaded[p.Version] {
+		typ, ok := columnTypes[p.Version]
+		if !ok {
+			return nil, nil, fmt.Errorf("version column %s not found", p.Version)
+		}
+		value, err := versionValue(typ, now)
+		if err != nil {
+			return nil, nil, fmt.Errorf("version column %s: %v", p.Version, err)
+		}
+		names = append(names, p.Version)
+		values = append(values, value)
+	}
+	return names, values, nil
+}
+
+// versionValue is the version of rows loaded at now: the time itself for
+// date/time columns, nanoseconds since the epoch for 64-bit and wider
+// integers and seconds for 32-bit ones.
+func versionValue(typ *chtypes.Type, now time.Time) (interface{}, error) {
+	switch typ.Kind {
+	case chtypes.DateTime, chtypes.DateTime64:
+		return now, nil
+	case chtypes.Int64, chtypes.UInt64, chtypes.Int128, chtypes.UInt128, chtypes.Int256, chtypes.UInt256:
+		return typ.Decode(strconv.FormatInt(now.UnixNano(), 10))
+	case chtypes.Int32, chtypes.UInt32:
+		return typ.Decode(strconv.FormatInt(now.Unix(), 10))
+	}
+	return nil, fmt.Errorf("cannot fill a %s version; load it from the file", typ)
+}
+
+// TruncateTable removes every row of database.table.
+func TruncateTable(ctx context.Context, conn driver.Conn, database, table string) error {
+	if err := conn.Exec(ctx, "TRUNCATE TABLE "+QualifiedTable(database, table)); err != nil {
+		return fmt.Errorf("failed to truncate table: %v", err)
+	}
+	return nil
+}
+
+// EngineArgs returns the column arguments of the table engine, such as the
+// version column of a ReplacingMergeTree. The path and replica name of a
+// replicated engine are left out.
+func (t TableInfo) EngineArgs() []string {
+	rest, ok := strings.CutPrefix(t.EngineFull, t.Engine+"(")
+	if !ok {
+		return nil
+	}
+	var args []string
+	depth, start := 0, 0
+	var quote byte
+	for i := 0; i < len(rest); i++ {
+		ch := rest[i]
+		switch {
+		case quote != 0:
+			if ch == '\\' {
+				i++
+			} else if ch == quote {
+				quote = 0
+			}
+		case ch == '\'' || ch == '`' || ch == '"':
+			quote = ch
+		case ch == '(':
+			depth++
+		case ch == ',' && depth == 0, ch == ')' && depth == 0:
+			if arg := strings.TrimSpace(rest[start:i]); arg != "" && arg[0] != '\'' {
+				args = append(args, strings.Trim(arg, "`\""))
+			}
+			if ch == ')' {
+				return args
+			}
+			start = i + 1
+		case ch == ')':
+			depth--
+		}
+	}
+	return nil
+}
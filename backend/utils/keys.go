@@ -0,0 +1,463 @@
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// minSecretLength is the shortest HMAC secret accepted from configuration.
+const minSecretLength = 32
+
+// KeyConfig describes where the token signing and verification keys come from.
+type KeyConfig struct {
+	// Algorithm is the signing algorithm: HS256, HS384, HS512, RS256, RS384,
+	// RS512, ES256, ES384 or ES512. When empty it follows from the key.
+	Algorithm string
+	// KeyID is the kid header of issued tokens. It defaults to a hash of the key.
+	KeyID string
+	// Secret is the HMAC secret for the HS algorithms.
+	Secret []byte
+	// PrivateKeyFile is a PEM encoded RSA or EC private key for the RS and ES algorithms.
+	PrivateKeyFile string
+	// VerifyKeys are additional "kid=path" entries for keys that are still
+	// accepted but no longer used for signing, e.g. the previous key during a
+	// rotation. A file holds a PEM public or private key, or an HMAC secret.
+	VerifyKeys []string
+}
+
+// KeyConfigFromEnv reads the key configuration from JWT_ALGORITHM, JWT_KEY_ID,
+// JWT_SECRET (or JWT_SECRET_FILE), JWT_PRIVATE_KEY_FILE and JWT_VERIFY_KEYS,
+// a comma separated list of kid=path entries.
+func KeyConfigFromEnv() (KeyConfig, error) {
+	config := KeyConfig{
+		Algorithm:      os.Getenv("JWT_ALGORITHM"),
+		KeyID:          os.Getenv("JWT_KEY_ID"),
+		Secret:         []byte(os.Getenv("JWT_SECRET")),
+		PrivateKeyFile: os.Getenv("JWT_PRIVATE_KEY_FILE"),
+	}
+	if path := os.Getenv("JWT_SECRET_FILE"); path != "" {
+		secret, err := os.ReadFile(path)
+		if err != nil {
+			return config, fmt.Errorf("failed to read JWT secret: %v", err)
+		}
+		config.Secret = []byte(strings.TrimSpace(string(secret)))
+	}
+	for _, entry := range strings.Split(os.Getenv("JWT_VERIFY_KEYS"), ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			config.VerifyKeys = append(config.VerifyKeys, entry)
+		}
+	}
+	return config, nil
+}
+
+// Key is a signing or verification key identified by its kid.
+type Key struct {
+	ID     string
+	Method jwt.SigningMethod
+	sign   interface{} // nil for verification-only keys
+	verify interface{}
+}
+
+// NewKey builds a key from an HMAC secret ([]byte), an *rsa.PrivateKey or
+// *ecdsa.PrivateKey, or, for verification only, an *rsa.PublicKey or
+// *ecdsa.PublicKey. An empty id is replaced by a hash of the key.
+func NewKey(id, algorithm string, material interface{}) (*Key, error) {
+	method := jwt.GetSigningMethod(algorithm)
+	if method == nil {
+		return nil, fmt.Errorf("unsupported JWT algorithm %q", algorithm)
+	}
+	key := &Key{ID: id, Method: method}
+
+	switch m := method.(type) {
+	case *jwt.SigningMethodHMAC:
+		secret, ok := material.([]byte)
+		if !ok {
+			return nil, fmt.Errorf("%s requires a secret", m.Alg())
+		}
+		key.sign, key.verify = secret, secret
+	case *jwt.SigningMethodRSA:
+		switch k := material.(type) {
+		case *rsa.PrivateKey:
+			key.sign, key.verify = k, &k.PublicKey
+		case *rsa.PublicKey:
+			key.verify = k
+		default:
+			return nil, fmt.Errorf("%s requires an RSA key", m.Alg())
+		}
+	case *jwt.SigningMethodECDSA:
+		var public *ecdsa.PublicKey
+		switch k := material.(type) {
+		case *ecdsa.PrivateKey:
+			key.sign, public = k, &k.PublicKey
+		case *ecdsa.PublicKey:
+			public = k
+		default:
+			return nil, fmt.Errorf("%s requires an EC key", m.Alg())
+		}
+		if public.Curve.Params().BitSize != m.CurveBits {
+			return nil, fmt.Errorf("%s requires a P-%d key", m.Alg(), m.CurveBits)
+		}
+		key.verify = public
+	default:
+		return nil, fmt.Errorf("unsupported JWT algorithm %q", algorithm)
+	}
+
+	if key.ID == "" {
+		id, err := keyID(key.verify)
+		if err != nil {
+			return nil, err
+		}
+		key.ID = id
+	}
+	return key, nil
+}
+
+// keyID derives a stable kid from the public part of a key.
+func keyID(material interface{}) (string, error) {
+	var data []byte
+	switch k := material.(type) {
+	case []byte:
+		data = k
+	default:
+		der, err := x509.MarshalPKIXPublicKey(k)
+		if err != nil {
+			return "", fmt.Errorf("failed to encode public key: %v", err)
+		}
+		data = der
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8]), nil
+}
+
+// KeySet holds the key new tokens are signed with and every key tokens are
+// still accepted from. Tokens name their key in the kid header.
+type KeySet struct {
+	mu      sync.RWMutex
+	signing *Key
+	keys    map[string]*Key
+	order   []string
+}
+
+// NewKeySet creates a key set that signs with signing and also accepts
+// tokens signed by any of verify.
+func NewKeySet(signing *Key, verify ...*Key) (*KeySet, error) {
+	if signing == nil || signing.sign == nil {
+		return nil, fmt.Errorf("signing key has no private part")
+	}
+	s := &KeySet{keys: make(map[string]*Key)}
+	for _, key := range append([]*Key{signing}, verify...) {
+		if err := s.add(key); err != nil {
+			return nil, err
+		}
+	}
+	s.signing = signing
+	return s, nil
+}
+
+func (s *KeySet) add(key *Key) error {
+	if _, ok := s.keys[key.ID]; ok {
+		return fmt.Errorf("duplicate JWT key id %q", key.ID)
+	}
+	s.keys[key.ID] = key
+	s.order = append(s.order, key.ID)
+	return nil
+}
+
+// Rotate makes next the signing key. The previous keys stay valid for
+// verification until they are retired.
+func (s *KeySet) Rotate(next *Key) error {
+	if next == nil || next.sign == nil {
+		return fmt.Errorf("signing key has no private part")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.keys[next.ID]; !ok {
+		if err := s.add(next); err != nil {
+			return err
+		}
+	}
+	s.signing = next
+	return nil
+}
+
+// Retire stops accepting tokens signed with the given key. The current
+// signing key cannot be retired.
+func (s *KeySet) Retire(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.signing.ID == id {
+		return fmt.Errorf("cannot retire the active signing key %q", id)
+	}
+	if _, ok := s.keys[id]; !ok {
+		return fmt.Errorf("unknown JWT key id %q", id)
+	}
+	delete(s.keys, id)
+	for i, kid := range s.order {
+		if kid == id {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// Sign signs claims with the current signing key and sets the kid header.
+func (s *KeySet) Sign(claims jwt.Claims) (string, error) {
+	s.mu.RLock()
+	key := s.signing
+	s.mu.RUnlock()
+
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+	signed, err := token.SignedString(key.sign)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign token: %v", err)
+	}
+	return signed, nil
+}
+
+// Parse verifies a token against the key named by its kid header, or the
+// signing key for tokens without one, and returns its claims.
+func (s *KeySet) Parse(tokenString string) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+
+		key := s.signing
+		if kid, ok := token.Header["kid"].(string); ok {
+			if key, ok = s.keys[kid]; !ok {
+				return nil, fmt.Errorf("unknown key id %q", kid)
+			}
+		}
+		// The algorithm is fixed by the key, never by the token.
+		if token.Method.Alg() != key.Method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return key.verify, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse token: %v", err)
+	}
+	if !token.Valid {
+		return nil, fmt.Errorf("invalid token")
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, fmt.Errorf("invalid claims format")
+	}
+	return claims, nil
+}
+
+// JWK is a public key in JSON Web Key format (RFC 7517).
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKSet is the document served at the JWKS endpoint.
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys of the set. HMAC secrets are never published,
+// so a set of HS keys yields an empty document.
+func (s *KeySet) JWKS() JWKSet {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	set := JWKSet{Keys: []JWK{}}
+	for _, id := range s.order {
+		key := s.keys[id]
+		jwk := JWK{Kid: key.ID, Use: "sig", Alg: key.Method.Alg()}
+		switch k := key.verify.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64URL(k.N.Bytes())
+			jwk.E = base64URL(big.NewInt(int64(k.E)).Bytes())
+		case *ecdsa.PublicKey:
+			ecdhKey, err := k.ECDH()
+			if err != nil {
+				continue
+			}
+			// Uncompressed point: 0x04 || X || Y, each coordinate padded to the curve size.
+			point := ecdhKey.Bytes()
+			size := (len(point) - 1) / 2
+			jwk.Kty = "EC"
+			jwk.Crv = k.Curve.Params().Name
+			jwk.X = base64URL(point[1 : 1+size])
+			jwk.Y = base64URL(point[1+size:])
+		default:
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
+
+func base64URL(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// LoadKeySet builds a key set from configuration. Without an algorithm, a
+// private key file selects RS256 or the ES algorithm of its curve, and a
+// secret selects HS256.
+func LoadKeySet(config KeyConfig) (*KeySet, error) {
+	algorithm := config.Algorithm
+	var material interface{}
+	if strings.HasPrefix(algorithm, "HS") || algorithm == "" && config.PrivateKeyFile == "" {
+		if algorithm == "" {
+			algorithm = jwt.SigningMethodHS256.Alg()
+		}
+		if len(config.Secret) < minSecretLength {
+			return nil, fmt.Errorf("JWT secret must be at least %d bytes", minSecretLength)
+		}
+		material = config.Secret
+	} else {
+		if config.PrivateKeyFile == "" {
+			return nil, fmt.Errorf("%s requires a private key file", algorithm)
+		}
+		key, err := readKeyFile(config.PrivateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		if algorithm == "" {
+			switch key.(type) {
+			case *rsa.PrivateKey, *ecdsa.PrivateKey:
+				algorithm = verifyAlgorithm(key, "")
+			default:
+				return nil, fmt.Errorf("cannot tell the algorithm of %s, which is not an RSA or EC private key; set JWT_ALGORITHM", config.PrivateKeyFile)
+			}
+		}
+		material = key
+	}
+	signing, err := NewKey(config.KeyID, algorithm, material)
+	if err != nil {
+		return nil, err
+	}
+
+	var verify []*Key
+	for _, entry := range config.VerifyKeys {
+		id, path, ok := strings.Cut(entry, "=")
+		if !ok || id == "" || path == "" {
+			return nil, fmt.Errorf("invalid verification key %q, expected kid=path", entry)
+		}
+		material, err := readKeyFile(path)
+		if err != nil {
+			return nil, err
+		}
+		key, err := NewKey(id, verifyAlgorithm(material, algorithm), material)
+		if err != nil {
+			return nil, fmt.Errorf("verification key %q: %v", id, err)
+		}
+		verify = append(verify, key)
+	}
+	return NewKeySet(signing, verify...)
+}
+
+// readKeyFile reads a PEM encoded RSA or EC key, public or private. Files
+// that are not PEM are treated as HMAC secrets.
+func readKeyFile(path string) (interface{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %v", err)
+	}
+	if block, _ := pem.Decode(data); block == nil {
+		return []byte(strings.TrimSpace(string(data))), nil
+	}
+
+	if key, err := jwt.ParseRSAPrivateKeyFromPEM(data); err == nil {
+		return key, nil
+	}
+	if key, err := jwt.ParseECPrivateKeyFromPEM(data); err == nil {
+		return key, nil
+	}
+	if key, err := jwt.ParseRSAPublicKeyFromPEM(data); err == nil {
+		return key, nil
+	}
+	if key, err := jwt.ParseECPublicKeyFromPEM(data); err == nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unsupported key in %s", path)
+}
+
+// verifyAlgorithm picks the algorithm for a verification key. The signing
+// algorithm is kept when it fits the key type, so rotating between two RS384
+// keys stays RS384.
+func verifyAlgorithm(material interface{}, preferred string) string {
+	switch k := material.(type) {
+	case *rsa.PrivateKey, *rsa.PublicKey:
+		if strings.HasPrefix(preferred, "RS") {
+			return preferred
+		}
+		return jwt.SigningMethodRS256.Alg()
+	case *ecdsa.PrivateKey:
+		return ecAlgorithm(&k.PublicKey)
+	case *ecdsa.PublicKey:
+		return ecAlgorithm(k)
+	}
+	if strings.HasPrefix(preferred, "HS") {
+		return preferred
+	}
+	return jwt.SigningMethodHS256.Alg()
+}
+
+// ecAlgorithm returns the ES algorithm for the curve of an EC key. P-521
+// keys sign ES512.
+func ecAlgorithm(k *ecdsa.PublicKey) string {
+	if bits := k.Curve.Params().BitSize; bits != 521 {
+		return fmt.Sprintf("ES%d", bits)
+	}
+	return jwt.SigningMethodES512.Alg()
+}
+
+var (
+	activeKeys    atomic.Pointer[KeySet]
+	ephemeralOnce sync.Once
+)
+
+// SetKeySet replaces the key set used by GenerateToken and ValidateToken.
+func SetKeySet(s *KeySet) {
+	activeKeys.Store(s)
+}
+
+// Keys returns the active key set. Until one is configured, a random HS256
+// secret is generated, so tokens do not survive a restart.
+func Keys() *KeySet {
+	ephemeralOnce.Do(func() {
+		if activeKeys.Load() != nil {
+			return
+		}
+		secret := make([]byte, minSecretLength)
+		if _, err := rand.Read(secret); err != nil {
+			log.Fatalf("failed to generate JWT secret: %v", err)
+		}
+		key, _ := NewKey("", jwt.SigningMethodHS256.Alg(), secret)
+		s, _ := NewKeySet(key)
+		activeKeys.CompareAndSwap(nil, s)
+		log.Println("No JWT key configured, using a random secret; tokens will not survive a restart")
+	})
+	return activeKeys.Load()
+}
@@ -4,6 +4,40 @@ import { FaCheckCircle, FaExclamationTriangle, FaSpinner, FaDatabase, FaFileAlt,
 import './App.css';
 import JWTTokenGenerator from './components/JWTTokenGenerator';
 
+// The backend keys ClickHouse connections by a session cookie
+axios.defaults.withCredentials = true;
+
+// Access tokens are short-lived: on a 401 the refresh token is exchanged for
+// a new pair once and the request is retried.
+let refreshToken = null;
+const setTokens = (tokens) => {
+    refreshToken = tokens.refreshToken;
+    axios.defaults.headers.common.Authorization = `Bearer ${tokens.accessToken}`;
+};
+axios.interceptors.response.use(undefined, async (error) => {
+    const request = error.config;
+    if (error.response?.status !== 401 || !refreshToken || request._retried || request.url.endsWith('/auth/refresh')) {
+        throw error;
+    }
+    request._retried = true;
+    const response = await axios.post('http://localhost:8080/auth/refresh', { refreshToken });
+    setTokens(response.data);
+    request.headers.Authorization = `Bearer ${response.data.accessToken}`;
+    return axios(request);
+});
+
+// Imports and exports run as background jobs: /ingest answers with a job ID
+// and the job is polled until it succeeds, fails or is canceled.
+const waitForJob = async (jobId) => {
+    for (;;) {
+        const res = await axios.get(`http://localhost:8080/jobs/${jobId}`);
+        if (!['queued', 'running'].includes(res.data.state)) {
+            return res.data;
+        }
+        await new Promise((resolve) => setTimeout(resolve, 1000));
+    }
+};
+
 function App() {
     const [sourceType, setSourceType] = useState('');
     const [targetType, setTargetType] = useState('');
@@ -15,7 +49,7 @@ function App() {
         password: '',
         jwtToken: '',
     });
-    const [flatFileConfig, setFlatFileConfig] = useState({ file: null, delimiter: ',', filePath: '' });
+    const [flatFileConfig, setFlatFileConfig] = useState({ file: null, delimiter: ',', uploadId: '' });
     const [tables, setTables] = useState([]);
     const [columns, setColumns] = useState([]);
     const [selectedTable, setSelectedTable] = useState('');
@@ -56,7 +90,7 @@ function App() {
             if (sourceType === 'clickhouse') {
                 endpoint = `http://localhost:8080/columns/clickhouse/${selectedTable}`;
             } else {
-                endpoint = `http://localhost:8080/columns/flatfile?filePath=${encodeURIComponent(flatFileConfig.filePath)}&delimiter=${flatFileConfig.delimiter}`;
+                endpoint = `http://localhost:8080/columns/flatfile?uploadId=${encodeURIComponent(flatFileConfig.uploadId)}&delimiter=${encodeURIComponent(flatFileConfig.delimiter)}`;
             }
             const res = await axios.get(endpoint);
             const columnList = res.data.map(col => col.name);
@@ -70,18 +104,18 @@ function App() {
     const handleFileUpload = async (e) => {
         const file = e.target.files[0];
         if (!file) return;
-        setFlatFileConfig({ ...flatFileConfig, file, filePath: '' });
+        setFlatFileConfig({ ...flatFileConfig, file, uploadId: '' });
         const formData = new FormData();
         formData.append('file', file);
-        formData.append('delimiter', flatFileConfig.delimiter);
 
         setStatus({ message: 'Uploading file...', type: 'loading' });
         try {
             const res = await axios.post('http://localhost:8080/upload/flatfile', formData);
-            const { filePath, delimiter } = res.data;
-            setFlatFileConfig({ ...flatFileConfig, file, filePath, delimiter });
+            // The backend detects the delimiter, quoting, header and encoding of the upload
+            const { id, format } = res.data;
+            setFlatFileConfig({ ...flatFileConfig, file, uploadId: id, delimiter: format.delimiter });
             setStatus({ message: 'File uploaded successfully', type: 'success' });
-            setSelectedTable(filePath);
+            setSelectedTable(id);
             setColumns([]);
             setSelectedColumns([]);
             setPreviewData(null);
@@ -95,7 +129,7 @@ function App() {
         try {
             const payload = {
                 source: sourceType,
-                table: sourceType === 'flatfile' ? flatFileConfig.filePath : selectedTable,
+                table: sourceType === 'flatfile' ? flatFileConfig.uploadId : selectedTable,
                 columns: selectedColumns,
             };
             const res = await axios.post('http://localhost:8080/preview', payload);
@@ -107,29 +141,55 @@ function App() {
         }
     };
 
+    // Exports are streamed from the backend and saved by the browser
+    const handleExport = async () => {
+        setStatus({ message: 'Exporting data...', type: 'loading' });
+        try {
+            const params = new URLSearchParams({ table: selectedTable });
+            selectedColumns.forEach((col) => params.append('columns', col));
+            const res = await axios.get(`http://localhost:8080/exports/stream?${params}`, { responseType: 'blob' });
+            const url = URL.createObjectURL(res.data);
+            const link = document.createElement('a');
+            link.href = url;
+            link.download = `${selectedTable}.csv`;
+            link.click();
+            URL.revokeObjectURL(url);
+            setStatus({ message: 'Export downloaded', type: 'success' });
+        } catch (err) {
+            setStatus({ message: `Error: ${err.response?.statusText || err.message}`, type: 'error' });
+        }
+    };
+
     const handleIngest = async () => {
+        if (sourceType === 'clickhouse' && targetType === 'flatfile') {
+            return handleExport();
+        }
         setStatus({ message: 'Ingesting data...', type: 'loading' });
         try {
             const payload = {
                 source: sourceType,
-                table: sourceType === 'flatfile' ? flatFileConfig.filePath : selectedTable,
+                table: sourceType === 'flatfile' ? flatFileConfig.uploadId : selectedTable,
                 columns: selectedColumns,
                 target: targetType,
                 output: targetType === 'clickhouse' ? 'uk_price_paid_import' : 'output_uk_price_paid.csv',
             };
             const res = await axios.post('http://localhost:8080/ingest', payload);
-            setRecordCount(res.data.recordCount);
+            const job = await waitForJob(res.data.jobId);
+            if (job.state !== 'succeeded') {
+                setStatus({ message: `Ingestion ${job.state}: ${job.error || 'no details'}`, type: 'error' });
+                return;
+            }
+            setRecordCount(job.result?.recordCount ?? null);
             setStatus({ message: 'Ingestion complete', type: 'success' });
         } catch (err) {
             setStatus({ message: `Error: ${err.response?.data?.error || err.message}`, type: 'error' });
         }
     };
 
-    const handleTokenGenerated = (token) => {
-        setClickHouseConfig(prev => ({
-            ...prev,
-            jwtToken: token
-        }));
+    // The API token authenticates requests to this backend; the jwtToken
+    // field above is only for logging in to ClickHouse itself.
+    const handleTokenGenerated = (tokens) => {
+        setTokens(tokens);
     };
 
     const isConnectDisabled = !sourceType || !targetType || 
@@ -137,12 +197,12 @@ function App() {
          (!clickHouseConfig.host || !clickHouseConfig.port || !clickHouseConfig.database));
 
     const isLoadColumnsDisabled = !selectedTable || 
-        (sourceType === 'flatfile' && !flatFileConfig.filePath);
+        (sourceType === 'flatfile' && !flatFileConfig.uploadId);
 
     const isPreviewDisabled = selectedColumns.length === 0;
 
     const isIngestDisabled = !selectedTable || selectedColumns.length === 0 || 
-        (sourceType === 'flatfile' && !flatFileConfig.filePath);
+        (sourceType === 'flatfile' && !flatFileConfig.uploadId);
 
     return (
         <div className="min-h-screen bg-gradient-to-br from-gray-50 to-gray-100">
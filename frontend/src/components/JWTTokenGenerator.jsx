@@ -3,13 +3,14 @@ import axios from 'axios';
 
 const JWTTokenGenerator = ({ onTokenGenerated }) => {
   const [username, setUsername] = useState('');
+  const [password, setPassword] = useState('');
   const [token, setToken] = useState('');
   const [error, setError] = useState('');
   const [loading, setLoading] = useState(false);
 
   const handleGenerateToken = async () => {
-    if (!username) {
-      setError('Please enter a username');
+    if (!username || !password) {
+      setError('Please enter a username and password');
       return;
     }
 
@@ -17,16 +18,15 @@ const JWTTokenGenerator = ({ onTokenGenerated }) => {
     setError('');
     
     try {
-      const response = await axios.post('http://localhost:8080/auth/token', { username });
-      const newToken = response.data.token;
-      setToken(newToken);
+      const response = await axios.post('http://localhost:8080/auth/token', { username, password });
+      setToken(response.data.accessToken);
       
-      // Call the callback function with the new token
+      // Call the callback function with the access and refresh tokens
       if (onTokenGenerated) {
-        onTokenGenerated(newToken);
+        onTokenGenerated(response.data);
       }
     } catch (err) {
-      setError(`Error generating token: ${err.response?.data?.error || err.message}`);
+      setError(`Error signing in: ${err.response?.data?.error || err.message}`);
     } finally {
       setLoading(false);
     }
@@ -48,6 +48,18 @@ const JWTTokenGenerator = ({ onTokenGenerated }) => {
             onChange={(e) => setUsername(e.target.value)}
           />
         </div>
+
+        <div>
+          <label htmlFor="password" className="block text-sm font-medium text-gray-700">Password</label>
+          <input
+            id="password"
+            type="password"
+            placeholder="Enter password"
+            className="mt-1 w-full p-2 border border-gray-300 rounded-md"
+            value={password}
+            onChange={(e) => setPassword(e.target.value)}
+          />
+        </div>
         
         <button
           onClick={handleGenerateToken}
@@ -56,7 +68,7 @@ const JWTTokenGenerator = ({ onTokenGenerated }) => {
             loading ? 'bg-gray-400' : 'bg-blue-600 hover:bg-blue-700'
           }`}
         >
-          {loading ? 'Generating...' : 'Generate Token'}
+          {loading ? 'Signing in...' : 'Sign In'}
         </button>
         
         {error && (